@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// AhoCorasickMatcher finds any of a set of fixed strings in a single pass
+// over the line, however many there are. It is the Matcher of a literal
+// search with several patterns. Where patterns overlap, the leftmost match
+// wins, and the longest of those starting at the same byte.
+type AhoCorasickMatcher struct {
+	nodes []acNode
+	// root holds the transitions of the root, which are taken on most
+	// bytes of a line, as a table instead of a map.
+	root [256]int32
+	Fold bool
+
+	// With WholeWord, matches must not touch word characters, as defined
+	// by IsWord or, when it is nil, by the regexp \b.
+	WholeWord bool
+	IsWord    func(rune) bool
+}
+
+type acNode struct {
+	next  map[byte]int32
+	fail  int32
+	dict  int32 // nearest node on the fail chain that ends a pattern, or 0
+	depth int32
+	end   bool
+}
+
+// NewAhoCorasickMatcher builds the automaton for patterns, which are already
+// lowercased when fold is set. Empty patterns are ignored.
+func NewAhoCorasickMatcher(patterns [][]byte, fold bool) *AhoCorasickMatcher {
+	m := &AhoCorasickMatcher{nodes: []acNode{{}}, Fold: fold}
+	for _, p := range patterns {
+		if len(p) == 0 {
+			continue
+		}
+		n := int32(0)
+		for _, b := range p {
+			next, ok := m.nodes[n].next[b]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, acNode{depth: m.nodes[n].depth + 1})
+				if m.nodes[n].next == nil {
+					m.nodes[n].next = make(map[byte]int32)
+				}
+				m.nodes[n].next[b] = next
+			}
+			n = next
+		}
+		m.nodes[n].end = true
+	}
+	m.link()
+	for b, n := range m.nodes[0].next {
+		m.root[b] = n
+	}
+	return m
+}
+
+// link sets the fail and dictionary links breadth first, so the links of
+// shorter prefixes are known when a node is reached.
+func (m *AhoCorasickMatcher) link() {
+	queue := make([]int32, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for b, v := range m.nodes[u].next {
+			f := m.nodes[u].fail
+			for f != 0 && !m.has(f, b) {
+				f = m.nodes[f].fail
+			}
+			if next, ok := m.nodes[f].next[b]; ok && next != v {
+				m.nodes[v].fail = next
+			}
+			fail := m.nodes[v].fail
+			if m.nodes[fail].end {
+				m.nodes[v].dict = fail
+			} else {
+				m.nodes[v].dict = m.nodes[fail].dict
+			}
+			queue = append(queue, v)
+		}
+	}
+}
+
+func (m *AhoCorasickMatcher) has(n int32, b byte) bool {
+	_, ok := m.nodes[n].next[b]
+	return ok
+}
+
+func (m *AhoCorasickMatcher) Match(line []byte) []Span {
+	var found []Span
+	m.scan(line, func(start, end int) bool {
+		found = append(found, Span{start, end})
+		return true
+	})
+	if len(found) < 2 {
+		return found
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Start != found[j].Start {
+			return found[i].Start < found[j].Start
+		}
+		return found[i].End > found[j].End
+	})
+	spans := found[:0]
+	last := 0
+	for _, sp := range found {
+		if sp.Start >= last {
+			spans = append(spans, sp)
+			last = sp.End
+		}
+	}
+	return spans
+}
+
+func (m *AhoCorasickMatcher) MatchLine(line []byte) bool {
+	found := false
+	m.scan(line, func(start, end int) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// scan calls fn with every occurrence of a pattern in line, in the order
+// they end, until fn returns false.
+func (m *AhoCorasickMatcher) scan(line []byte, fn func(start, end int) bool) {
+	s := line
+	// ASCII lines are folded byte by byte as they are scanned.
+	asciiFold := m.Fold && isASCII(line)
+	if m.Fold && !asciiFold {
+		s = foldCase(line, true)
+	}
+	state := int32(0)
+	for i, b := range s {
+		if asciiFold {
+			b = asciiLower[b]
+		}
+		for {
+			if state == 0 {
+				state = m.root[b]
+				break
+			}
+			if next, ok := m.nodes[state].next[b]; ok {
+				state = next
+				break
+			}
+			state = m.nodes[state].fail
+		}
+		n := state
+		if !m.nodes[n].end {
+			n = m.nodes[n].dict
+		}
+		for ; n != 0; n = m.nodes[n].dict {
+			start, end := i+1-int(m.nodes[n].depth), i+1
+			if (!m.WholeWord || m.wordEdges(s, start, end)) && !fn(start, end) {
+				return
+			}
+		}
+	}
+}
+
+// wordEdges reports whether s[start:end] is a whole word.
+func (m *AhoCorasickMatcher) wordEdges(s []byte, start, end int) bool {
+	if m.IsWord != nil {
+		before, _ := utf8.DecodeLastRune(s[:start])
+		after, _ := utf8.DecodeRune(s[end:])
+		return (start == 0 || !m.IsWord(before)) && (end == len(s) || !m.IsWord(after))
+	}
+	return regexpBoundary(s, start) && regexpBoundary(s, end)
+}
+
+// regexpBoundary reports whether the regexp \b matches at s[i]: exactly one
+// of the bytes around it is an ASCII word character.
+func regexpBoundary(s []byte, i int) bool {
+	before := i > 0 && isASCIIWord(s[i-1])
+	after := i < len(s) && isASCIIWord(s[i])
+	return before != after
+}
+
+func isASCIIWord(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
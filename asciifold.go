@@ -0,0 +1,46 @@
+package main
+
+import "unicode/utf8"
+
+// asciiLower maps every byte to its lowercase form, changing only the ASCII
+// letters A to Z.
+var asciiLower = func() (t [256]byte) {
+	for i := range t {
+		t[i] = byte(i)
+		if 'A' <= i && i <= 'Z' {
+			t[i] = byte(i) + 'a' - 'A'
+		}
+	}
+	return t
+}()
+
+// isASCII reports whether s holds only ASCII bytes. Case-insensitive
+// matchers then fold with asciiLower instead of a full Unicode lowercase
+// copy of the line.
+func isASCII(s []byte) bool {
+	for _, b := range s {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFoldASCII is bytes.Index for a lowercase query that ignores the case
+// of the ASCII letters of s, without copying it.
+func indexFoldASCII(s, query []byte) int {
+	first := query[0]
+	for i := 0; i+len(query) <= len(s); i++ {
+		if asciiLower[s[i]] != first {
+			continue
+		}
+		j := 1
+		for j < len(query) && asciiLower[s[i+j]] == query[j] {
+			j++
+		}
+		if j == len(query) {
+			return i
+		}
+	}
+	return -1
+}
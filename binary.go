@@ -0,0 +1,17 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// binarySniffLen is how much of a file is inspected for NUL bytes, the same
+// heuristic grep uses to tell binary from text.
+const binarySniffLen = 8000
+
+// looksBinary reports whether the buffered content starts with data that
+// contains a NUL byte. It only peeks, so reader is left untouched.
+func looksBinary(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(binarySniffLen)
+	return bytes.IndexByte(head, 0) >= 0
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// blockBufferLimit caps the number of matches of a file held in memory.
+// Past it the buffered matches are sorted and spilled to a temporary file,
+// and the runs are merged back when the file is printed.
+const blockBufferLimit = 10000
+
+// BlockReporter holds the results of every file back until the file has been
+// searched and then passes them on as one block, in line order. Files are
+// searched in parallel, and so are the chunks of one file, so this is what
+// keeps a reader from seeing the lines of two files, or the lines of one file
+// out of order, mixed up. Files come out in the order they finish.
+type BlockReporter struct {
+	mu    sync.Mutex
+	inner Reporter
+	limit int
+	files map[string]*fileBlock
+}
+
+// fileBlock is the buffered results of one file: the matches not spilled yet
+// and the temporary files holding the sorted runs spilled so far.
+type fileBlock struct {
+	path    string
+	matches []Match
+	runs    []*os.File
+}
+
+// NewBlockReporter wraps inner for --buffer-per-file.
+func NewBlockReporter(inner Reporter) *BlockReporter {
+	return &BlockReporter{inner: inner, limit: blockBufferLimit, files: make(map[string]*fileBlock)}
+}
+
+func (r *BlockReporter) Begin(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[path] = &fileBlock{path: path}
+}
+
+func (r *BlockReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.files[m.Path]
+	if f == nil {
+		// Not searched by Process, so there is no end to wait for.
+		r.inner.Match(m)
+		return
+	}
+	f.matches = append(f.matches, m)
+	if len(f.matches) >= r.limit {
+		f.spill()
+	}
+}
+
+func (r *BlockReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inner.Count(path, n)
+}
+
+func (r *BlockReporter) End(path string, matches int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f := r.files[path]; f != nil {
+		delete(r.files, path)
+		r.flush(f)
+	}
+	if events, ok := r.inner.(FileEvents); ok {
+		events.End(path, matches)
+	}
+}
+
+// Close prints the files a cancelled scan left unfinished, in path order.
+func (r *BlockReporter) Close() error {
+	r.mu.Lock()
+	paths := make([]string, 0, len(r.files))
+	for path := range r.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		r.flush(r.files[path])
+	}
+	r.files = nil
+	r.mu.Unlock()
+	return r.inner.Close()
+}
+
+// flush passes the block of f on to inner, announced by Begin when inner
+// wants file events.
+func (r *BlockReporter) flush(f *fileBlock) {
+	if events, ok := r.inner.(FileEvents); ok {
+		events.Begin(f.path)
+	}
+	sortByLine(f.matches)
+	if len(f.runs) == 0 {
+		for _, m := range f.matches {
+			r.inner.Match(m)
+		}
+		return
+	}
+	if err := f.merge(r.inner.Match); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read back the results of %s: %v\n", f.path, err)
+	}
+}
+
+// spill writes the buffered matches of f, sorted, to a new temporary file.
+// If that fails they stay in memory.
+func (f *fileBlock) spill() {
+	sortByLine(f.matches)
+	run, err := os.CreateTemp("", "findme-block-*")
+	if err == nil {
+		// The file is only reached through run from now on.
+		os.Remove(run.Name())
+		w := bufio.NewWriter(run)
+		enc := gob.NewEncoder(w)
+		for i := range f.matches {
+			if err = enc.Encode(&f.matches[i]); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			_, err = run.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			run.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: keeping the results of %s in memory: %v\n", f.path, err)
+		return
+	}
+	f.runs = append(f.runs, run)
+	f.matches = nil
+}
+
+// merge calls emit for every match of f in line order, merging the spilled
+// runs with the matches still in memory, and closes the runs.
+func (f *fileBlock) merge(emit func(Match)) error {
+	type source struct {
+		next func() (Match, bool, error)
+		head Match
+	}
+	var sources []*source
+	for _, run := range f.runs {
+		defer run.Close()
+		dec := gob.NewDecoder(bufio.NewReader(run))
+		sources = append(sources, &source{next: func() (Match, bool, error) {
+			var m Match
+			if err := dec.Decode(&m); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return m, false, err
+			}
+			return m, true, nil
+		}})
+	}
+	rest := f.matches
+	sources = append(sources, &source{next: func() (Match, bool, error) {
+		if len(rest) == 0 {
+			return Match{}, false, nil
+		}
+		m := rest[0]
+		rest = rest[1:]
+		return m, true, nil
+	}})
+
+	live := sources[:0]
+	for _, s := range sources {
+		m, ok, err := s.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.head = m
+			live = append(live, s)
+		}
+	}
+	// There are few runs, so picking the smallest head by a linear scan
+	// is cheaper than keeping a heap. Earlier runs win ties, which keeps
+	// the matches of one line in the order they were reported.
+	for len(live) > 0 {
+		first := 0
+		for i := 1; i < len(live); i++ {
+			if lineBefore(live[i].head, live[first].head) {
+				first = i
+			}
+		}
+		s := live[first]
+		emit(s.head)
+		m, ok, err := s.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.head = m
+		} else {
+			live = append(live[:first], live[first+1:]...)
+		}
+	}
+	return nil
+}
+
+// sortByLine orders matches by line. The sort is stable so the matches of one
+// line, e.g. with --only-matching, keep their order.
+func sortByLine(matches []Match) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return lineBefore(matches[i], matches[j])
+	})
+}
+
+func lineBefore(a, b Match) bool {
+	return a.Line < b.Line
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBlockReporter(t *testing.T) {
+	for _, limit := range []int{blockBufferLimit, 3} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			rep := newCaptureReporter()
+			r := NewBlockReporter(rep)
+			r.limit = limit
+
+			r.Begin("a")
+			r.Begin("b")
+			r.Begin("c")
+			// The chunks of a file are matched in parallel, so its lines
+			// come in out of order and mixed with other files.
+			for _, m := range []Match{
+				{Path: "a", Line: 5}, {Path: "b", Line: 2}, {Path: "a", Line: 1, Start: 0},
+				{Path: "a", Line: 1, Start: 4}, {Path: "b", Line: 1}, {Path: "a", Line: 3},
+				{Path: "c", Line: 2}, {Path: "a", Line: 2}, {Path: "a", Line: 4}, {Path: "c", Line: 1},
+			} {
+				r.Match(m)
+			}
+			r.End("b", 2)
+			r.End("a", 5)
+			// A file with no Begin is passed through at once.
+			r.Match(Match{Path: "stdin", Line: 7})
+			// c is left unfinished, as by a cancelled scan.
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, m := range rep.matches {
+				got = append(got, fmt.Sprintf("%s:%d:%d", m.Path, m.Line, m.Start))
+			}
+			want := []string{"b:1:0", "b:2:0", "a:1:0", "a:1:4", "a:2:0", "a:3:0", "a:4:0", "a:5:0", "stdin:7:0", "c:1:0", "c:2:0"}
+			equalLines(t, got, want)
+			if !rep.closed {
+				t.Error("inner reporter not closed")
+			}
+		})
+	}
+}
+
+// BenchmarkBlocks prints three files where every line matches, as they
+// are found and one block per file, which spills its results past
+// blockBufferLimit.
+func BenchmarkBlocks(b *testing.B) {
+	var text bytes.Buffer
+	for i := 0; text.Len() < 2<<20; i++ {
+		fmt.Fprintf(&text, "needle %d\n", i)
+	}
+	files := map[string]string{"a.txt": text.String(), "b.txt": text.String(), "c.txt": text.String()}
+	root := writeTree(b, files)
+	for _, buffered := range []bool{false, true} {
+		b.Run(fmt.Sprintf("buffer-per-file=%v", buffered), func(b *testing.B) {
+			b.SetBytes(int64(3 * text.Len()))
+			for i := 0; i < b.N; i++ {
+				opts, _ := testOptions(b, "needle", func(o *SearchOptions) {
+					o.Workers = 3
+					o.Reporter = discardReporter{}
+					if buffered {
+						o.Reporter = NewBlockReporter(discardReporter{})
+					}
+				})
+				search(b, root, []string{root}, opts, nil)
+				opts.Reporter.Close()
+			}
+		})
+	}
+}
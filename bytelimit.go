@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+)
+
+// byteLimit is the --max-bytes budget shared by every file of a run. The
+// methods do nothing on a nil *byteLimit, which has no limit.
+type byteLimit struct {
+	max     int64
+	used    atomic.Int64
+	reached atomic.Bool
+	cancel  context.CancelFunc
+}
+
+// newByteLimit returns a budget of max bytes that ends the scan with cancel
+// once it is spent.
+func newByteLimit(max int64, cancel context.CancelFunc) *byteLimit {
+	return &byteLimit{max: max, cancel: cancel}
+}
+
+// take returns the part of data that still fits in the budget, cut after the
+// last delim so that no line is searched in part, and reports whether the
+// budget is spent. The caller searches what it got and then calls stop.
+func (l *byteLimit) take(data []byte, delim byte) ([]byte, bool) {
+	if l == nil {
+		return data, false
+	}
+	total := l.used.Add(int64(len(data)))
+	if total <= l.max {
+		return data, false
+	}
+	l.reached.Store(true)
+	room := int64(len(data)) - (total - l.max)
+	if room <= 0 {
+		return nil, true
+	}
+	return data[:bytes.LastIndexByte(data[:room], delim)+1], true
+}
+
+// hit reports whether the budget is spent.
+func (l *byteLimit) hit() bool {
+	return l != nil && l.reached.Load()
+}
+
+// stop cancels the scan once the budget is spent.
+func (l *byteLimit) stop() {
+	if l.hit() {
+		l.cancel()
+	}
+}
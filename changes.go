@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+)
+
+// changeReport collects the changes of a --replace-dry-run-json run and
+// writes them as one JSON document, so tools can show a refactoring preview
+// in their own UI.
+type changeReport struct {
+	mu    sync.Mutex
+	w     io.Writer
+	files []jsonFileChanges
+}
+
+// jsonChange is one changed line.
+type jsonChange struct {
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// jsonFileChanges lists the changes of one file.
+type jsonFileChanges struct {
+	Path         string       `json:"path"`
+	Replacements int          `json:"replacements"`
+	Changes      []jsonChange `json:"changes"`
+}
+
+type jsonChangeReport struct {
+	Version      int               `json:"version"`
+	FilesChanged int64             `json:"files_changed"`
+	Replacements int64             `json:"replacements"`
+	Files        []jsonFileChanges `json:"files"`
+}
+
+func (r *changeReport) add(path string, replacements int, changes []jsonChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, jsonFileChanges{Path: displayPath(path), Replacements: replacements, Changes: changes})
+}
+
+// write prints the report, with the totals of the run, and the files sorted
+// by path. A run without any change still prints a valid document with an
+// empty file list.
+func (r *changeReport) write(filesChanged, replacements int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := r.files
+	if files == nil {
+		files = []jsonFileChanges{}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	enc := json.NewEncoder(r.w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonChangeReport{Version: SchemaVersion, FilesChanged: filesChanged, Replacements: replacements, Files: files})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gookit/color"
+)
+
+// Values of --color.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// setColor turns colored output on or off for the --color value when. With
+// auto, colors are only written to a terminal, so pipes and files get plain
+// text.
+func setColor(when string, terminal bool) error {
+	switch when {
+	case ColorAuto:
+		if !terminal {
+			color.Enable = false
+		}
+	case ColorAlways:
+		color.Enable = true
+		color.ForceOpenColor()
+	case ColorNever:
+		color.Enable = false
+	default:
+		return fmt.Errorf("unknown --color %q (expected auto, always or never)", when)
+	}
+	return nil
+}
+
+// ColorScheme is the style of each part of a text result.
+type ColorScheme struct {
+	Path   color.Style
+	Line   color.Style
+	Column color.Style
+	Match  color.Style
+}
+
+// colorSpec is a style being built from --colors values. Zero colors are
+// unset.
+type colorSpec struct {
+	fg, bg  color.Color
+	options []color.Color
+}
+
+func (s colorSpec) style() color.Style {
+	var style color.Style
+	if s.fg != 0 {
+		style = append(style, s.fg)
+	}
+	if s.bg != 0 {
+		style = append(style, s.bg)
+	}
+	return append(style, s.options...)
+}
+
+// defaultColorSpecs are the styles used when --colors does not change them.
+func defaultColorSpecs() map[string]*colorSpec {
+	return map[string]*colorSpec{
+		"path":   {fg: color.FgMagenta},
+		"line":   {fg: color.FgGreen},
+		"column": {fg: color.FgGreen},
+		"match":  {fg: color.FgLightWhite, bg: color.BgRed},
+	}
+}
+
+// ParseColors builds the ColorScheme from --colors values of the form
+// type:fg:color, type:bg:color, type:style:option or type:none, where type
+// is path, line, column or match. Later values override earlier ones.
+func ParseColors(specs []string) (*ColorScheme, error) {
+	styles := defaultColorSpecs()
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		s, ok := styles[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid --colors %q: unknown type %q (expected path, line, column or match)", spec, parts[0])
+		}
+		if len(parts) == 2 && parts[1] == "none" {
+			*s = colorSpec{}
+			continue
+		}
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid --colors %q (expected type:fg:color, type:bg:color, type:style:option or type:none)", spec)
+		}
+		if err := s.set(parts[1], parts[2]); err != nil {
+			return nil, fmt.Errorf("invalid --colors %q: %v", spec, err)
+		}
+	}
+	return &ColorScheme{
+		Path:   styles["path"].style(),
+		Line:   styles["line"].style(),
+		Column: styles["column"].style(),
+		Match:  styles["match"].style(),
+	}, nil
+}
+
+func (s *colorSpec) set(attr, value string) error {
+	switch attr {
+	case "fg", "bg":
+		c, ok := color.FgColors[value]
+		if !ok {
+			c, ok = color.ExFgColors[value]
+		}
+		if !ok {
+			return fmt.Errorf("unknown color %q", value)
+		}
+		if attr == "fg" {
+			s.fg = c
+		} else {
+			s.bg = c.ToBg()
+		}
+	case "style":
+		if value == "underline" {
+			value = "underscore"
+		}
+		c, ok := color.AllOptions[value]
+		if !ok {
+			return fmt.Errorf("unknown style %q", value)
+		}
+		s.options = append(s.options, c)
+	default:
+		return fmt.Errorf("unknown attribute %q (expected fg, bg or style)", attr)
+	}
+	return nil
+}
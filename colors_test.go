@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gookit/color"
+)
+
+func TestColorFlag(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a needle\n"})
+	tests := []struct {
+		args  []string
+		color bool
+	}{
+		// The output of the tests is a file, not a terminal.
+		{nil, false},
+		{[]string{"--color", "auto"}, false},
+		{[]string{"--color", "never"}, false},
+		{[]string{"--color", "always"}, true},
+		{[]string{"--pretty"}, true},
+		{[]string{"--pretty", "--color", "never"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string{"-d", root, "-q", "needle", "-n"}, tt.args...)
+			stdout, _, err := runSearch(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(stdout, "\x1b["); got != tt.color {
+				t.Errorf("colored = %v, want %v: %q", got, tt.color, stdout)
+			}
+			if plain := color.ClearCode(stdout); !strings.Contains(plain, "1:a needle\n") {
+				t.Errorf("output %q lacks the match", plain)
+			}
+		})
+	}
+
+	if _, _, err := runSearch(t, "-d", root, "-q", "needle", "--color", "sometimes"); err == nil || !strings.Contains(err.Error(), "unknown --color") {
+		t.Errorf("--color sometimes: err = %v", err)
+	}
+}
+
+func TestHeadingColors(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "needle\nhay\nneedle\n", "b.txt": "needle\n"})
+	a, b := filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt")
+	tests := []struct {
+		name  string
+		args  []string
+		want  string
+		color bool
+	}{
+		{"heading", []string{"--heading", "-n"}, a + "\n1:needle\n3:needle\n\n" + b + "\n1:needle\n", false},
+		{"pretty", []string{"--pretty"}, a + "\n1:needle\n3:needle\n\n" + b + "\n1:needle\n", true},
+		{"pretty without color", []string{"--pretty", "--color", "never"}, a + "\n1:needle\n3:needle\n\n" + b + "\n1:needle\n", false},
+		{"count", []string{"--count"}, a + ":2\n" + b + ":1\n", false},
+		{"pretty count", []string{"--pretty", "--count"}, a + ":2\n" + b + ":1\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-d", root, "-q", "needle", "--sort-files"}, tt.args...)
+			stdout, _, err := runSearch(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(stdout, "\x1b["); got != tt.color {
+				t.Errorf("colored = %v, want %v: %q", got, tt.color, stdout)
+			}
+			if plain := color.ClearCode(stdout); plain != tt.want {
+				t.Errorf("output %q, want %q", plain, tt.want)
+			}
+		})
+	}
+}
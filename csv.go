@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// CSVReporter writes a table with a row per match for --format csv and tsv.
+// The columns are the path and line of the match and, in regex mode, the
+// value of each named group, so (?P<ip>\S+) \S+ \S+ \[(?P<time>[^]]+)\]
+// turns an access log into path,line,ip,time. A query without named groups
+// gets a single match column with the matched text. The counting modes
+// write path,count, path or total instead.
+type CSVReporter struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	opts   *SearchOptions
+	tally  countTally
+	header bool
+
+	// groups are the named groups of the regex and index the submatch of
+	// each; a name used twice takes the first group that matched.
+	groups  []string
+	indexes [][]int
+}
+
+// NewCSVReporter returns the reporter for --format csv, or for tsv with
+// comma set to a tab. Unless opts.NoHeader is set, the first row names the
+// columns.
+func NewCSVReporter(w io.Writer, opts *SearchOptions, comma rune) *CSVReporter {
+	r := &CSVReporter{w: csv.NewWriter(w), opts: opts, header: !opts.NoHeader}
+	r.w.Comma = comma
+	if opts.Re != nil {
+		r.groups, r.indexes = namedGroups(opts.Re)
+	}
+	return r
+}
+
+// checkCSVGroups rejects named groups of re that would share the name of
+// the path or line column.
+func checkCSVGroups(re *regexp.Regexp) error {
+	for _, name := range re.SubexpNames() {
+		if name == "path" || name == "line" {
+			return fmt.Errorf("the group (?P<%s>...) cannot be a column of --format csv or tsv, which has a %s column of its own; rename it", name, name)
+		}
+	}
+	return nil
+}
+
+// namedGroups returns the distinct names of the named groups of re, in the
+// order of their first use, with the submatch indexes of each name.
+func namedGroups(re *regexp.Regexp) ([]string, [][]int) {
+	var names []string
+	var indexes [][]int
+	seen := make(map[string]int)
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if j, ok := seen[name]; ok {
+			indexes[j] = append(indexes[j], i)
+			continue
+		}
+		seen[name] = len(names)
+		names = append(names, name)
+		indexes = append(indexes, []int{i})
+	}
+	return names, indexes
+}
+
+// columns returns the header row for the run.
+func (r *CSVReporter) columns() []string {
+	switch r.opts.CountMode {
+	case CountLines:
+		return []string{"path", "count"}
+	case CountFilesWith, CountFilesWithout:
+		return []string{"path"}
+	case CountTotal:
+		return []string{"total"}
+	}
+	if r.groups == nil {
+		return []string{"path", "line", "match"}
+	}
+	return append([]string{"path", "line"}, r.groups...)
+}
+
+// write writes a row, preceded by the header the first time. Callers hold
+// mu.
+func (r *CSVReporter) write(row []string) {
+	if r.header {
+		r.w.Write(r.columns())
+		r.header = false
+	}
+	r.w.Write(row)
+}
+
+// Match writes a row for each match of m. Only the spans of m are written,
+// so --only-matching, which reports every match on its own, gets no
+// duplicate rows.
+func (r *CSVReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path, line := reportPath(r.opts, m.Path), strconv.Itoa(m.Line)
+	if r.groups == nil {
+		for _, sp := range m.Spans {
+			r.write([]string{path, line, m.Text[sp.Start:sp.End]})
+		}
+		r.w.Flush()
+		return
+	}
+
+	spans := make(map[Span]bool, len(m.Spans))
+	for _, sp := range m.Spans {
+		spans[sp] = true
+	}
+	for _, sub := range r.opts.Re.FindAllStringSubmatchIndex(m.Text, -1) {
+		if !spans[Span{Start: sub[0], End: sub[1]}] {
+			continue
+		}
+		row := []string{path, line}
+		for _, indexes := range r.indexes {
+			value := ""
+			for _, i := range indexes {
+				if sub[2*i] >= 0 {
+					value = m.Text[sub[2*i]:sub[2*i+1]]
+					break
+				}
+			}
+			row = append(row, value)
+		}
+		r.write(row)
+	}
+	r.w.Flush()
+}
+
+func (r *CSVReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.tally.add(r.opts, n) {
+		return
+	}
+	if r.opts.CountMode == CountLines {
+		r.write([]string{reportPath(r.opts, path), strconv.Itoa(n)})
+	} else {
+		r.write([]string{reportPath(r.opts, path)})
+	}
+	r.w.Flush()
+}
+
+// Close writes the total of --count-total, or the header alone when no row
+// was written, so even an empty result is a table.
+func (r *CSVReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opts.CountMode == CountTotal {
+		r.write([]string{strconv.FormatInt(r.tally.total, 10)})
+	} else if r.header {
+		r.w.Write(r.columns())
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// visitedFiles remembers the files queued so far by their real path, so a
+// file reached through a symlink, from two search roots or listed twice is
+// searched once. A nil *visitedFiles lets every file through.
+type visitedFiles struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newVisitedFiles() *visitedFiles {
+	return &visitedFiles{seen: make(map[string]struct{})}
+}
+
+// first reports whether path is the first of the paths seen that leads to
+// its file. A path whose real path cannot be resolved is let through; the
+// reader reports the error.
+func (v *visitedFiles) first(path string) bool {
+	if v == nil {
+		return true
+	}
+	real, err := realPath(path)
+	if err != nil {
+		return true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.seen[real]; ok {
+		return false
+	}
+	v.seen[real] = struct{}{}
+	return true
+}
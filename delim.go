@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// ParseLineDelimiter returns the byte of a --line-delimiter value: a single
+// character, or one of the escapes \n, \r, \t, \0 and \xHH.
+func ParseLineDelimiter(s string) (byte, error) {
+	switch s {
+	case "", `\n`:
+		return '\n', nil
+	case `\r`:
+		return '\r', nil
+	case `\t`:
+		return '\t', nil
+	case `\0`:
+		return 0, nil
+	}
+	if len(s) == 4 && s[:2] == `\x` {
+		b, err := strconv.ParseUint(s[2:], 16, 8)
+		if err == nil {
+			return byte(b), nil
+		}
+	}
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	return 0, fmt.Errorf("invalid --line-delimiter %q (expected a single character or \\n, \\r, \\t, \\0 or \\xHH)", s)
+}
+
+// scanRecords returns the split function for records ending in delim. For
+// '\n' it is bufio.ScanLines, which also drops the \r of CRLF endings; any
+// other delimiter is taken literally and the rest of the record is kept as
+// is, newlines included.
+func scanRecords(delim byte) bufio.SplitFunc {
+	if delim == '\n' {
+		return bufio.ScanLines
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// trimRecord drops what is left of the line ending of a record split by
+// scanRecords. Only newline-delimited records have one.
+func trimRecord(record []byte, delim byte) []byte {
+	if delim != '\n' {
+		return record
+	}
+	return bytes.TrimRight(record, "\r\n")
+}
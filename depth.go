@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// unlimitedDepth is the MaxDepth of a search that descends into every
+// subdirectory.
+const unlimitedDepth = -1
+
+// searchDepth returns the MaxDepth for the --recursive and --max-depth flags.
+// A depth given with --max-depth wins; otherwise --recursive removes the cap
+// and a plain search only reads the files directly inside the roots.
+func searchDepth(recursive bool, maxDepth int, maxDepthSet bool) (int, error) {
+	switch {
+	case maxDepthSet && maxDepth < 0:
+		return 0, fmt.Errorf("--max-depth must not be negative")
+	case maxDepthSet:
+		return maxDepth, nil
+	case recursive:
+		return unlimitedDepth, nil
+	}
+	return 0, nil
+}
+
+// tooDeep reports whether the directory at rel, a path relative to the search
+// root, lies below the depth limit. The directories directly inside the root
+// are at depth 1.
+func tooDeep(rel string, maxDepth int) bool {
+	return maxDepth != unlimitedDepth && strings.Count(filepath.ToSlash(rel), "/")+1 > maxDepth
+}
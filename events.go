@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// FormatEvents is the --events stream. It is not one of the --format values
+// because it reports the progress of the scan, not just its results.
+const FormatEvents = "events"
+
+// Event types of the --events stream.
+const (
+	EventBegin   = "begin"
+	EventMatch   = "match"
+	EventEnd     = "end"
+	EventSummary = "summary"
+	EventError   = "error"
+	EventSkip    = "skip"
+)
+
+// FileEvents is implemented by reporters that want to know when the search
+// of a file starts and ends. Process calls Begin before reading a file and
+// End once every selected line of it has been reported.
+type FileEvents interface {
+	Begin(path string)
+	End(path string, matches int)
+}
+
+// EventsReporter writes one typed JSON object per line: begin and end around
+// each file, a match for every selected line, an error for every file that
+// could not be searched, a skip for every file left out and a summary on
+// Close. Every line is written whole under the lock, so the stream stays
+// parseable when the scan is cancelled.
+type EventsReporter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	opts    *SearchOptions
+	files   int
+	matched int
+	matches int64
+}
+
+// NewEventsReporter returns an EventsReporter writing to w.
+func NewEventsReporter(w io.Writer, opts *SearchOptions) *EventsReporter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &EventsReporter{enc: enc, opts: opts}
+}
+
+type eventFile struct {
+	Type string `json:"type"`
+	jsonFile
+}
+
+type eventMatch struct {
+	Type string `json:"type"`
+	jsonMatch
+}
+
+type eventEnd struct {
+	Type string `json:"type"`
+	jsonCount
+}
+
+// eventError is an error or skip event. Kind is one of the FileError kinds,
+// so a program can tell a permission problem from a malformed archive
+// without parsing Message.
+type eventError struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+	Message string `json:"message,omitempty"`
+}
+
+type eventSummary struct {
+	Type             string `json:"type"`
+	Version          int    `json:"version"`
+	Files            int    `json:"files"`
+	FilesWithMatches int    `json:"files_with_matches"`
+	Matches          int64  `json:"matches"`
+}
+
+func (r *EventsReporter) Begin(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(eventFile{Type: EventBegin, jsonFile: jsonFile{Version: SchemaVersion, Path: reportPath(r.opts, path)}})
+}
+
+func (r *EventsReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(eventMatch{Type: EventMatch, jsonMatch: newJSONMatch(r.opts, m)})
+}
+
+// Count is a no-op: the end event of every file already carries its count.
+func (r *EventsReporter) Count(path string, n int) {}
+
+func (r *EventsReporter) End(path string, matches int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files++
+	if matches > 0 {
+		r.matched++
+	}
+	r.matches += int64(matches)
+	r.enc.Encode(eventEnd{Type: EventEnd, jsonCount: jsonCount{Version: SchemaVersion, Path: reportPath(r.opts, path), Count: matches}})
+}
+
+func (r *EventsReporter) FileError(e FileError) {
+	r.writeError(EventError, e)
+}
+
+func (r *EventsReporter) FileSkipped(e FileError) {
+	r.writeError(EventSkip, e)
+}
+
+func (r *EventsReporter) writeError(typ string, e FileError) {
+	ev := eventError{Type: typ, Version: SchemaVersion, Path: reportPath(r.opts, e.Path), Kind: e.Kind}
+	if e.Err != nil {
+		ev.Message = e.Err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(ev)
+}
+
+func (r *EventsReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(eventSummary{
+		Type:             EventSummary,
+		Version:          SchemaVersion,
+		Files:            r.files,
+		FilesWithMatches: r.matched,
+		Matches:          r.matches,
+	})
+}
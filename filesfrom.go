@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// openFileList opens the --files-from argument; "-" is standard input.
+func openFileList(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// listFileNames sends the paths read from r, one per line or, with delim
+// set to NUL, one per NUL-terminated record, straight to the readers. The
+// walker, its filters and the ignore files are bypassed: the list is taken
+// as the exact set of files to search. Empty names are skipped and
+// directories are reported and skipped.
+func listFileNames(ctx context.Context, r io.Reader, delim byte, opts *SearchOptions, fileChan chan<- string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	scanner.Split(scanRecords(delim))
+	for scanner.Scan() {
+		path := string(trimRecord(scanner.Bytes(), delim))
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Warning: skipping directory %s listed in --files-from\n", path)
+			continue
+		}
+		if !queueFile(ctx, path, opts, fileChan) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Error reading --files-from: %v\n", err)
+	}
+}
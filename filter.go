@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathFilter holds the glob filters given with --include, --exclude and
+// --exclude-dir.
+type PathFilter struct {
+	Include    []string
+	Exclude    []string
+	ExcludeDir []string
+}
+
+// NewPathFilter validates the patterns and returns the filter.
+func NewPathFilter(include, exclude, excludeDir []string) (*PathFilter, error) {
+	for _, patterns := range [][]string{include, exclude, excludeDir} {
+		for _, p := range patterns {
+			if _, err := path.Match(filepath.ToSlash(p), ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", p, err)
+			}
+		}
+	}
+	return &PathFilter{Include: include, Exclude: exclude, ExcludeDir: excludeDir}, nil
+}
+
+// newPathFilterFrom is NewPathFilter with the globs of the --include-from
+// and --exclude-from files added after those of --include and --exclude.
+func newPathFilterFrom(include, exclude, excludeDir, includeFrom, excludeFrom []string) (*PathFilter, error) {
+	for _, name := range includeFrom {
+		globs, err := readGlobs(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading --include-from file: %w", err)
+		}
+		include = append(include, globs...)
+	}
+	for _, name := range excludeFrom {
+		globs, err := readGlobs(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading --exclude-from file: %w", err)
+		}
+		exclude = append(exclude, globs...)
+	}
+	return NewPathFilter(include, exclude, excludeDir)
+}
+
+// readGlobs returns the globs of an --include-from or --exclude-from file,
+// one per line. Blank lines and lines starting with # are skipped, so the
+// file can be commented like a .gitignore. An invalid glob is reported with
+// its line.
+func readGlobs(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var globs []string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		glob := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(glob) == "" || strings.HasPrefix(glob, "#") {
+			continue
+		}
+		if _, err := path.Match(filepath.ToSlash(glob), ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid glob %q: %w", name, lineNum, glob, err)
+		}
+		globs = append(globs, glob)
+	}
+	return globs, scanner.Err()
+}
+
+// SkipDir reports whether the walk should not descend into the directory at
+// rel, a path relative to the search root.
+func (f *PathFilter) SkipDir(rel string) bool {
+	return f != nil && matchAnyGlob(f.ExcludeDir, rel)
+}
+
+// SkipFile reports whether the file at rel should not be searched.
+func (f *PathFilter) SkipFile(rel string) bool {
+	if f == nil {
+		return false
+	}
+	if len(f.Include) > 0 && !matchAnyGlob(f.Include, rel) {
+		return true
+	}
+	return matchAnyGlob(f.Exclude, rel)
+}
+
+// matchAnyGlob reports whether rel matches one of the patterns. A pattern
+// without a slash is matched against the base name, one with a slash against
+// the whole relative path.
+func matchAnyGlob(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := path.Base(rel)
+	for _, p := range patterns {
+		p = filepath.ToSlash(p)
+		name := base
+		if strings.Contains(p, "/") {
+			name = rel
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// filePredicate is a test on the metadata of a file for the find command.
+type filePredicate func(info os.FileInfo) bool
+
+// sizeUnits are the suffixes of --size, in powers of 1024.
+var sizeUnits = map[string]int64{"": 1, "b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+
+// ageUnits are the suffixes of --mtime.
+var ageUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+// splitComparison splits an expression such as >=10k into its operator and
+// operand. Without an operator the operand must be equal.
+func splitComparison(expr string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(expr, op) {
+			return op, strings.TrimSpace(expr[len(op):])
+		}
+	}
+	return "=", strings.TrimSpace(expr)
+}
+
+func compare(op string, a, b int64) bool {
+	switch op {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	}
+	return a == b
+}
+
+// parseSizePredicate parses a --size expression such as >1M or <=512k.
+func parseSizePredicate(expr string) (filePredicate, error) {
+	op, operand := splitComparison(expr)
+	num := strings.TrimRightFunc(operand, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := sizeUnits[strings.ToLower(operand[len(num):])]
+	n, err := strconv.ParseInt(num, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("invalid --size %q (expected e.g. >1M, <=512k or 0; units b, k, M, G, T)", expr)
+	}
+	size := n * unit
+	return func(info os.FileInfo) bool { return compare(op, info.Size(), size) }, nil
+}
+
+// parseAgePredicate parses an --mtime expression such as <7d, the files
+// modified in the last seven days, or >1w, those last modified before that.
+// The age is measured from now.
+func parseAgePredicate(expr string, now time.Time) (filePredicate, error) {
+	op, operand := splitComparison(expr)
+	num := strings.TrimRightFunc(operand, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := ageUnits[operand[len(num):]]
+	n, err := strconv.ParseInt(num, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("invalid --mtime %q (expected e.g. <7d or >2h; units s, m, h, d, w)", expr)
+	}
+	age := int64(time.Duration(n) * unit)
+	return func(info os.FileInfo) bool { return compare(op, int64(now.Sub(info.ModTime())), age) }, nil
+}
+
+func newFindCommand() *cli.Command {
+	var dirPath, nameRegex string
+	var isRecursive, null, ignoreGlobal bool
+	var maxDepth int
+	var sizes, mtimes, ignoreFiles, include, exclude, excludeDir, includeFrom, excludeFrom cli.StringSlice
+
+	return &cli.Command{
+		Name:  "find",
+		Usage: "List the files whose name, size and modification time match, without reading them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "dir",
+				Aliases:     []string{"d"},
+				Usage:       "Directory to search in; globs are expanded",
+				Destination: &dirPath,
+				Required:    true,
+			},
+			&cli.BoolFlag{
+				Name:        "recursive",
+				Aliases:     []string{"R"},
+				Usage:       "Include subdirectories",
+				Destination: &isRecursive,
+			},
+			&cli.IntFlag{
+				Name:        "max-depth",
+				Usage:       "Descend at most N directory levels; 0 lists only the files directly inside the directory",
+				Destination: &maxDepth,
+			},
+			&cli.StringFlag{
+				Name:        "name-regex",
+				Usage:       "Only list files whose name, without the directory, matches this regular expression",
+				Destination: &nameRegex,
+			},
+			&cli.StringSliceFlag{
+				Name:        "size",
+				Usage:       "Only list files of this size, e.g. '>1M', '<=512k' or 0 (repeatable; all must hold)",
+				Destination: &sizes,
+			},
+			&cli.StringSliceFlag{
+				Name:        "mtime",
+				Usage:       "Only list files last modified within ('<7d') or before ('>2h') this age (repeatable; all must hold)",
+				Destination: &mtimes,
+			},
+			&cli.StringSliceFlag{
+				Name:        "include",
+				Usage:       "Only list files whose name matches the glob (repeatable)",
+				Destination: &include,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude",
+				Usage:       "Skip files whose name matches the glob (repeatable)",
+				Destination: &exclude,
+			},
+			&cli.StringSliceFlag{
+				Name:        "include-from",
+				Usage:       "Also only search files matching the globs in this file, one per line (repeatable)",
+				Destination: &includeFrom,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude-from",
+				Usage:       "Also skip files matching the globs in this file, one per line (repeatable)",
+				Destination: &excludeFrom,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude-dir",
+				Usage:       "Do not descend into directories whose name matches the glob (repeatable)",
+				Destination: &excludeDir,
+			},
+			&cli.StringSliceFlag{
+				Name:        "ignore-file",
+				Usage:       "Skip paths matching the gitignore-style patterns in this file (repeatable)",
+				Destination: &ignoreFiles,
+			},
+			&cli.BoolFlag{
+				Name:        "respect-ignore-global",
+				Usage:       "Also skip paths matching the user's global gitignore (core.excludesFile or ~/.config/git/ignore)",
+				Destination: &ignoreGlobal,
+			},
+			&cli.BoolFlag{
+				Name:        "null",
+				Usage:       "End each name with NUL instead of a newline, for xargs -0 or --files-from --null",
+				Destination: &null,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			var predicates []filePredicate
+			if nameRegex != "" {
+				re, err := regexp.Compile(nameRegex)
+				if err != nil {
+					return fmt.Errorf("invalid --name-regex %q: %v", nameRegex, err)
+				}
+				predicates = append(predicates, func(info os.FileInfo) bool { return re.MatchString(info.Name()) })
+			}
+			for _, expr := range sizes.Value() {
+				p, err := parseSizePredicate(expr)
+				if err != nil {
+					return err
+				}
+				predicates = append(predicates, p)
+			}
+			now := time.Now()
+			for _, expr := range mtimes.Value() {
+				p, err := parseAgePredicate(expr, now)
+				if err != nil {
+					return err
+				}
+				predicates = append(predicates, p)
+			}
+
+			depth, err := searchDepth(isRecursive, maxDepth, c.IsSet("max-depth"))
+			if err != nil {
+				return err
+			}
+			opts := &SearchOptions{MaxDepth: depth}
+			roots, err := ExpandRoots(dirPath)
+			if err != nil {
+				return err
+			}
+			if opts.Ignore, err = loadIgnoreFiles(roots, ignoreFiles.Value(), ignoreGlobal); err != nil {
+				return err
+			}
+			if opts.Filter, err = newPathFilterFrom(include.Value(), exclude.Value(), excludeDir.Value(), includeFrom.Value(), excludeFrom.Value()); err != nil {
+				return err
+			}
+
+			end := "\n"
+			if null {
+				end = "\x00"
+			}
+			return findFiles(c.Context, roots, opts, predicates, func(path string) {
+				fmt.Print(displayPath(path), end)
+			})
+		},
+	}
+}
+
+// findFiles walks the roots with the same walker and filters as a search
+// and calls found, in walk order, for every file that all predicates accept.
+// Files are only stat'ed, never opened.
+func findFiles(ctx context.Context, roots []string, opts *SearchOptions, predicates []filePredicate, found func(path string)) error {
+	fileChan := make(chan string)
+	go func() {
+		defer close(fileChan)
+		for _, root := range roots {
+			listFiles(ctx, root, opts, fileChan)
+		}
+	}()
+
+files:
+	for path := range fileChan {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for _, p := range predicates {
+			if !p(info) {
+				continue files
+			}
+		}
+		found(path)
+	}
+	return ctx.Err()
+}
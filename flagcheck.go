@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// flagConflict names flags that cannot be given together with flag, with an
+// optional reason for the error. Combinations that depend on the values of
+// the flags, such as the counting modes or --top N, are checked where those
+// values are resolved in Action.
+type flagConflict struct {
+	flag   string
+	with   []string
+	reason string
+}
+
+// searchFlagConflicts are the combinations the search command rejects up
+// front instead of quietly ignoring one of the flags.
+var searchFlagConflicts = []flagConflict{
+	{flag: "dir", with: []string{"files-from"}},
+	{flag: "files-from", with: []string{"git-tracked", "follow"}},
+	{flag: "sort-files", with: []string{"workers"}, reason: "it reads one file at a time"},
+	{flag: "head", with: []string{"tail"}},
+	{flag: "lines", with: []string{"head", "tail"}, reason: "--lines gives the range itself"},
+	{flag: "lines", with: []string{"multiline", "match-whole-file", "follow", "replace", "replace-pair"}, reason: "it only restricts a line by line search"},
+	{flag: "drop-invalid", with: []string{"multiline", "match-whole-file"}, reason: "it skips single lines"},
+	{flag: "events", with: []string{"format"}, reason: "--events is an output format of its own"},
+	{
+		flag:   "quiet",
+		with:   []string{"format", "count", "files-with-matches", "files-without-match", "count-total", "top", "group-by-count", "events", "follow", "replace", "replace-pair"},
+		reason: "--quiet prints nothing",
+	},
+	{
+		flag:   "group-by-count",
+		with:   []string{"count", "files-with-matches", "files-without-match", "count-total", "events", "follow", "match-whole-file"},
+		reason: "it prints the matching lines once every file has been searched",
+	},
+	{flag: "top", with: []string{"files-with-matches", "files-without-match", "count-total", "events", "include-zero"}, reason: "--top ranks the files with the most matching lines"},
+	{
+		flag:   "match-whole-file",
+		with:   []string{"multiline", "fuzzy", "whole-word", "only-matching", "head", "tail", "and", "not", "follow", "replace", "replace-pair"},
+		reason: "it matches the content of each file as a whole",
+	},
+	{flag: "fuzzy", with: []string{"whole-word"}, reason: "a fuzzy match need not end on a word boundary"},
+	{flag: "multiline", with: []string{"invert-match", "head", "tail", "and", "not"}, reason: "it matches across lines"},
+	{flag: "unique", with: uniqueCountFlags, reason: "the counting modes print no lines to deduplicate"},
+	{flag: "unique-per-file", with: uniqueCountFlags, reason: "the counting modes print no lines to deduplicate"},
+	{
+		flag:   "follow",
+		with:   []string{"count", "files-with-matches", "files-without-match", "count-total", "top", "max-count", "multiline", "head", "tail", "ordered-by-file", "buffer-per-file", "replace", "replace-pair"},
+		reason: "it prints the lines as they are appended, and the files have no end",
+	},
+	{flag: "min-line-length", with: []string{"multiline", "match-whole-file"}, reason: "it skips single lines"},
+	{flag: "max-line-length", with: []string{"multiline", "match-whole-file"}, reason: "it skips single lines"},
+	{flag: "replace-dry-run-json", with: []string{"format", "events", "replace-interactive"}, reason: "it writes a JSON document of its own"},
+	{flag: "count", with: []string{"only-matching"}, reason: "--count counts matching lines, not matches"},
+	{flag: "only-matching", with: []string{"invert-match"}, reason: "an inverted line has no match to print"},
+	{flag: "replace-interactive", with: []string{"dry-run"}},
+	{
+		flag:   "context-scope",
+		with:   []string{"count", "files-with-matches", "files-without-match", "count-total", "quiet", "multiline", "match-whole-file", "follow", "replace", "replace-pair"},
+		reason: "scopes are shown with matching lines only",
+	},
+	{
+		flag:   "preview-lines",
+		with:   []string{"count", "files-with-matches", "files-without-match", "count-total", "top", "quiet", "format", "events", "follow", "replace", "replace-pair"},
+		reason: "it shortens the matching lines printed under each heading",
+	},
+	{flag: "replace", with: replaceOutputFlags, reason: "--replace rewrites the matches and only reports what it changed"},
+	{flag: "replace-pair", with: replaceOutputFlags, reason: "--replace-pair rewrites the matches and only reports what it changed"},
+	{flag: "replace-pair", with: []string{"query", "patterns-file", "replace"}, reason: "the pairs give both the patterns and their replacements"},
+}
+
+// replaceOutputFlags are the output modes a replace has no use for.
+var replaceOutputFlags = []string{"count", "files-with-matches", "files-without-match", "count-total", "include-zero", "top", "group-by-count", "events", "format", "only-matching", "invert-match", "unique", "unique-per-file"}
+
+// uniqueCountFlags are the modes that count instead of printing lines,
+// including --top, which prints counts, and --quiet and --match-whole-file,
+// which run as --files-with-matches.
+var uniqueCountFlags = []string{"count", "files-with-matches", "files-without-match", "count-total", "top", "quiet", "match-whole-file"}
+
+// checkFlagConflicts returns an error for the first conflict whose flags
+// were all given on the command line.
+func checkFlagConflicts(c *cli.Context, conflicts []flagConflict) error {
+	for _, conflict := range conflicts {
+		if !flagGiven(c, conflict.flag) {
+			continue
+		}
+		for _, other := range conflict.with {
+			if !flagGiven(c, other) {
+				continue
+			}
+			if conflict.reason != "" {
+				return fmt.Errorf("--%s cannot be combined with --%s: %s", conflict.flag, other, conflict.reason)
+			}
+			return fmt.Errorf("--%s and --%s are mutually exclusive", conflict.flag, other)
+		}
+	}
+	return nil
+}
+
+// flagGiven reports whether name was given on the command line with an
+// effect: a flag set to its default, such as --count=false, --head 0 or
+// --format text, is not. An empty string still is, as in --replace ”.
+func flagGiven(c *cli.Context, name string) bool {
+	if !c.IsSet(name) {
+		return false
+	}
+	value := c.Value(name)
+	switch f := lookupFlag(c, name).(type) {
+	case *cli.BoolFlag:
+		return value != f.Value
+	case *cli.IntFlag:
+		return value != f.Value
+	case *cli.Int64Flag:
+		return value != f.Value
+	case *cli.StringFlag:
+		return f.Value == "" || value != f.Value
+	}
+	return true
+}
+
+// lookupFlag returns the flag of the command of c that is called name, or
+// nil.
+func lookupFlag(c *cli.Context, name string) cli.Flag {
+	if c.Command == nil {
+		return nil
+	}
+	for _, f := range c.Command.Flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlagConflicts(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "a needle\n"})
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--count", "--only-matching"}, "--count cannot be combined with --only-matching: --count counts matching lines, not matches"},
+		{[]string{"--head", "1", "--tail", "1"}, "--head and --tail are mutually exclusive"},
+		{[]string{"--quiet", "--format", "json"}, "--quiet cannot be combined with --format"},
+		{[]string{"--quiet", "--count"}, "--quiet cannot be combined with --count"},
+		{[]string{"--quiet", "--replace-pair", "needle=pin"}, "--quiet cannot be combined with --replace-pair"},
+		{[]string{"--group-by-count", "--events"}, "--group-by-count cannot be combined with --events"},
+		{[]string{"--top", "2", "--count-total"}, "--top cannot be combined with --count-total"},
+		{[]string{"--top", "2", "--count", "--include-zero"}, "--top cannot be combined with --include-zero"},
+		{[]string{"--match-whole-file", "--and", "x"}, "--match-whole-file cannot be combined with --and"},
+		{[]string{"--fuzzy", "1", "--whole-word"}, "--fuzzy cannot be combined with --whole-word"},
+		{[]string{"--regex", "--multiline", "--tail", "3"}, "--multiline cannot be combined with --tail"},
+		{[]string{"--unique", "--files-with-matches"}, "--unique cannot be combined with --files-with-matches"},
+		{[]string{"--unique-per-file", "--quiet"}, "--unique-per-file cannot be combined with --quiet"},
+		{[]string{"--follow", "--max-count", "3"}, "--follow cannot be combined with --max-count"},
+		{[]string{"--min-line-length", "3", "--match-whole-file"}, "--min-line-length cannot be combined with --match-whole-file"},
+		{[]string{"--replace", "pin", "--replace-dry-run-json", "--format", "jsonl"}, "--replace-dry-run-json cannot be combined with --format: it writes a JSON document of its own"},
+		{[]string{"--replace-dry-run-json", "--replace-interactive"}, "--replace-dry-run-json cannot be combined with --replace-interactive"},
+		{[]string{"--replace", "", "--count"}, "--replace cannot be combined with --count"},
+
+		// Value-dependent checks stay in Action.
+		{[]string{"--regex", "--fuzzy", "1"}, "--fuzzy cannot be combined with --regex"},
+		{[]string{"--regex", "--whole-word"}, `--whole-word cannot be combined with --regex: put \b around the pattern instead`},
+		{[]string{"--multiline"}, "--multiline requires --regex"},
+		{[]string{"--include-zero"}, "--include-zero requires --count"},
+
+		// Flags at their default have no effect and do not conflict.
+		{[]string{"--quiet", "--format", "text"}, ""},
+		{[]string{"--count=false", "--only-matching"}, ""},
+		{[]string{"--head", "0", "--tail", "1"}, ""},
+		{[]string{"--top", "0", "--count-total"}, ""},
+		{[]string{"--fixed-strings", "--regex", "--fuzzy", "1"}, ""},
+		{[]string{"--fixed-strings", "--regex", "--whole-word"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string{"-d", root, "-q", "needle"}, tt.args...)
+			_, _, err := runSearch(t, args...)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
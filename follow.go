@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// followPollInterval is how often --follow checks for appended data and, when
+// following a directory, for new files.
+var followPollInterval = 250 * time.Millisecond
+
+// followedFile is a file watched by --follow. It stays open so that data
+// written before a log rotation is still read.
+type followedFile struct {
+	file *os.File
+	info os.FileInfo
+
+	// line and offset locate the start of pending, the part of the file
+	// read so far that does not end with a newline yet.
+	line    int
+	offset  int64
+	pending []byte
+}
+
+// followFiles searches the lines appended to the files below roots until ctx
+// is cancelled, like tail -f piped into grep. Files present at the start are
+// followed from their end; files created later are searched from their first
+// line. flush is called after every poll so that buffered output shows up.
+func followFiles(ctx context.Context, roots []string, opts *SearchOptions, flush func() error) error {
+	files := make(map[string]*followedFile)
+	// seen holds every path listed so far. Each is counted as walked, and
+	// a failure to open it reported, once, however many polls list it.
+	seen := make(map[string]bool)
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	for initial := true; ; initial = false {
+		for _, path := range followCandidates(ctx, roots, opts) {
+			if _, ok := files[path]; ok {
+				continue
+			}
+			first := !seen[path]
+			if first {
+				seen[path] = true
+				opts.Stats.walked()
+			}
+			f, err := openFollowed(path, initial || isFollowed(files, path), opts.Delimiter)
+			if err != nil {
+				if first {
+					opts.Stats.skipped()
+					opts.Errors.report(FileError{Path: path, Kind: ErrorRead, Err: err}, "Error opening file %s: %v", path, err)
+				}
+				continue
+			}
+			files[path] = f
+		}
+
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if !files[path].poll(ctx, path, opts) {
+				files[path].file.Close()
+				delete(files, path)
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isFollowed reports whether path is a file that is already followed under
+// another name, as happens to a log renamed by rotation. Its content was
+// read under the old name, so it is followed from its end.
+func isFollowed(files map[string]*followedFile, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if os.SameFile(info, f.info) {
+			return true
+		}
+	}
+	return false
+}
+
+// followCandidates lists the files below roots with the same filters as a
+// normal search. The same files are listed again on every poll, so the
+// listing leaves the walked count to followFiles.
+func followCandidates(ctx context.Context, roots []string, opts *SearchOptions) []string {
+	list := *opts
+	list.Stats = nil
+	fileChan := make(chan string)
+	go func() {
+		defer close(fileChan)
+		for _, root := range roots {
+			listFiles(ctx, root, &list, fileChan)
+		}
+	}()
+	var paths []string
+	for path := range fileChan {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// openFollowed opens path for following. With atEnd, the current content is
+// skipped: its lines are counted so that later matches get their absolute
+// line numbers, and an unterminated last line is kept as pending. Lines end
+// in delim.
+func openFollowed(path string, atEnd bool, delim byte) (*followedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &followedFile{file: file, info: info, line: 1}
+	if !atEnd {
+		return f, nil
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		data := buf[:n]
+		for {
+			i := bytes.IndexByte(data, delim)
+			if i < 0 {
+				break
+			}
+			f.line++
+			f.offset += int64(len(f.pending) + i + 1)
+			f.pending = f.pending[:0]
+			data = data[i+1:]
+		}
+		f.pending = append(f.pending, data...)
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+}
+
+// poll searches the complete lines appended since the last call. It reports
+// false when the file is gone and should no longer be followed.
+func (f *followedFile) poll(ctx context.Context, path string, opts *SearchOptions) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		// Removed; read what was written before, then stop.
+		if !errors.Is(err, fs.ErrNotExist) {
+			opts.Errors.report(FileError{Path: path, Kind: ErrorRead, Err: err}, "Error reading file %s: %v", path, err)
+		}
+		f.read(ctx, path, opts)
+		return false
+	}
+	if !os.SameFile(info, f.info) {
+		// Rotated: finish the old file and start over with the new one.
+		f.read(ctx, path, opts)
+		file, err := os.Open(path)
+		if err != nil {
+			opts.Errors.report(FileError{Path: path, Kind: ErrorRead, Err: err}, "Error opening file %s: %v", path, err)
+			return false
+		}
+		f.file.Close()
+		*f = followedFile{file: file, info: info, line: 1}
+	} else if info.Size() < f.offset+int64(len(f.pending)) {
+		// Truncated in place, e.g. by copytruncate.
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+		f.line, f.offset, f.pending = 1, 0, f.pending[:0]
+	}
+	f.read(ctx, path, opts)
+	return true
+}
+
+// read consumes the data appended to the open file and searches every line
+// completed by it.
+func (f *followedFile) read(ctx context.Context, path string, opts *SearchOptions) {
+	data, err := io.ReadAll(f.file)
+	if err != nil {
+		opts.Errors.report(FileError{Path: path, Kind: ErrorRead, Err: err}, "Error reading file %s: %v", path, err)
+	}
+	f.pending = append(f.pending, data...)
+	end := bytes.LastIndexByte(f.pending, opts.Delimiter) + 1
+	if end == 0 {
+		return
+	}
+
+	c := chunk{data: f.pending[:end], startLine: f.line, offset: f.offset}
+	searchChunk(ctx, c, path, opts)
+	f.line += bytes.Count(c.data, []byte{opts.Delimiter})
+	f.offset += int64(end)
+	f.pending = append([]byte(nil), f.pending[end:]...)
+}
+
+// searchChunk runs a single chunk through a chunk worker and waits for it.
+func searchChunk(ctx context.Context, c chunk, fileName string, opts *SearchOptions) {
+	chunkChan := make(chan chunk, 1)
+	chunkChan <- c
+	close(chunkChan)
+
+	// The worker recycles the chunk buffer; a private pool keeps the
+	// pending buffer from being reused elsewhere.
+	var pool sync.Pool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processChunkWorker(ctx, chunkChan, &pool, fileName, opts, &fileCount{stop: func() {}}, nil, &wg)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowErrors(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "needle\n"})
+	opts, rep := testOptions(t, "needle", nil)
+
+	// A directory opens, but reading it fails.
+	dir, err := os.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &followedFile{file: dir, line: 1}
+	defer f.file.Close()
+	f.read(context.Background(), root, opts)
+
+	// a.txt is no directory, so the stat fails without the path being
+	// gone.
+	bad := filepath.Join(root, "a.txt", "x")
+	g, err := openFollowed(filepath.Join(root, "a.txt"), true, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.file.Close()
+	if g.poll(context.Background(), bad, opts) {
+		t.Error("a file that cannot be stat'ed is still followed")
+	}
+
+	// The messages are dropped, as with --no-messages, but every error
+	// is an event and counts towards the exit status.
+	if len(rep.errors) != 2 || rep.errors[0].Path != root || rep.errors[1].Path != bad {
+		t.Errorf("error events %v, want one for %s and one for %s", rep.errors, root, bad)
+	}
+	for _, e := range rep.errors {
+		if e.Kind != ErrorRead {
+			t.Errorf("error %v, want kind %s", e, ErrorRead)
+		}
+	}
+	if n := opts.Errors.count(); n != 2 {
+		t.Errorf("%d errors counted, want 2", n)
+	}
+}
+
+func TestFollowStats(t *testing.T) {
+	stub(t, &followPollInterval, time.Millisecond)
+	root := writeTree(t, map[string]string{"a.log": "old needle\n"})
+	path := filepath.Join(root, "a.log")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts, rep := testOptions(t, "needle", func(o *SearchOptions) { o.Stats = NewRunStats() })
+
+	// Every poll ends with a flush; the first three append a line each.
+	const polls = 5
+	flushes := 0
+	flush := func() error {
+		flushes++
+		if flushes <= 3 {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(f, "needle %d\n", flushes)
+			f.Close()
+		}
+		if flushes == polls {
+			cancel()
+		}
+		return nil
+	}
+	if err := followFiles(ctx, []string{root}, opts, flush); err != context.Canceled {
+		t.Fatalf("followFiles returned %v, want context.Canceled", err)
+	}
+
+	equalLines(t, rep.lines(root), []string{"a.log:2:needle 1", "a.log:3:needle 2", "a.log:4:needle 3"})
+	// The file is listed on every poll but walked once.
+	if n := opts.Stats.filesWalked.Load(); n != 1 {
+		t.Errorf("%d files walked over %d polls, want 1", n, polls)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "unicode/utf8"
+
+// fuzzyIndex finds the first substring of text within edit distance k of
+// pattern, counting insertions, deletions and substitutions of runes. It
+// returns the byte offsets of the substring, or -1, -1. Once a match ends, it
+// is extended for as long as that lowers the distance, so "hello" matches all
+// of "hello" rather than "hell".
+//
+// This is Sellers' dynamic programme over the text with Ukkonen's cut-off:
+// only the pattern prefixes that can still be within k are computed for each
+// rune, so the cost is O(k·len(text)) on typical input instead of
+// O(len(pattern)·len(text)).
+func fuzzyIndex(text, pattern string, k int) (int, int) {
+	pat := []rune(pattern)
+	m := len(pat)
+	if m <= k {
+		return 0, 0
+	}
+
+	// dist[i] is the edit distance between pat[:i] and the best substring
+	// ending at the current text position, capped at k+1; from[i] is where
+	// that substring starts.
+	dist, from := make([]int, m+1), make([]int, m+1)
+	next, nextFrom := make([]int, m+1), make([]int, m+1)
+	for i := range dist {
+		dist[i] = min(i, k+1)
+	}
+	last := k // the last row with dist <= k
+
+	start, end, best := -1, -1, k+1
+	for p, r := range text {
+		_, size := utf8.DecodeRuneInString(text[p:])
+		after := p + size
+		next[0], nextFrom[0] = 0, after
+		top := min(last+1, m)
+		for i := 1; i <= top; i++ {
+			d, f := dist[i-1], from[i-1]
+			if pat[i-1] != r {
+				d++
+			}
+			if next[i-1]+1 < d {
+				d, f = next[i-1]+1, nextFrom[i-1]
+			}
+			if dist[i]+1 < d {
+				d, f = dist[i]+1, from[i]
+			}
+			next[i], nextFrom[i] = min(d, k+1), f
+		}
+		for i := top + 1; i <= m; i++ {
+			next[i] = k + 1
+		}
+		dist, next = next, dist
+		from, nextFrom = nextFrom, from
+
+		last = top
+		for last > 0 && dist[last] > k {
+			last--
+		}
+
+		switch {
+		case dist[m] < best:
+			start, end, best = from[m], after, dist[m]
+		case start >= 0:
+			return start, end
+		}
+	}
+	return start, end
+}
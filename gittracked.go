@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// listGitFiles sends the files under root that git tracks to fileChan, in
+// place of a walk of root. With opts.GitUntracked, the untracked files that
+// are not ignored are listed too. The --include, --exclude, --exclude-dir
+// and --ignore-file filters still apply. Tracked files that were deleted
+// from the work tree are skipped, and so are submodules.
+func listGitFiles(ctx context.Context, root string, opts *SearchOptions, fileChan chan<- string) error {
+	dir, pathspec := root, "."
+	if info, err := os.Stat(root); err != nil {
+		return err
+	} else if !info.IsDir() {
+		dir, pathspec = filepath.Dir(root), filepath.Base(root)
+	}
+
+	args := []string{"-C", dir, "ls-files", "-z", "--cached"}
+	if opts.GitUntracked {
+		args = append(args, "--others", "--exclude-standard")
+	}
+	cmd := exec.CommandContext(ctx, "git", append(args, "--", pathspec)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("--git-tracked: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	scanner.Split(scanRecords(0))
+	for scanner.Scan() {
+		// git lists paths relative to dir with forward slashes.
+		name := filepath.FromSlash(scanner.Text())
+		path := filepath.Join(dir, name)
+		// Like the walk, a file given as the root is not filtered.
+		if pathspec == "." && skipGitFile(root, name, opts) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		if !queueFile(ctx, path, opts, fileChan) {
+			cmd.Wait()
+			return ctx.Err()
+		}
+	}
+	scanErr := scanner.Err()
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("--git-tracked: %s", msg)
+		}
+		return fmt.Errorf("--git-tracked: %w", err)
+	}
+	return scanErr
+}
+
+// skipGitFile applies the filters of the walk to a listed file: rel is
+// skipped when it or one of its parent directories is excluded.
+func skipGitFile(root, rel string, opts *SearchOptions) bool {
+	if opts.Filter.SkipFile(rel) || opts.Ignore.Match(root, rel, false) {
+		return true
+	}
+	if dir := filepath.Dir(rel); dir != "." && tooDeep(dir, opts.MaxDepth) {
+		return true
+	}
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		if opts.Filter.SkipDir(dir) || opts.Ignore.Match(root, dir, true) {
+			return true
+		}
+	}
+	return false
+}
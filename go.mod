@@ -2,12 +2,16 @@ module findme
 
 go 1.22.1
 
+require (
+	github.com/bmatcuk/doublestar/v4 v4.10.2
+	github.com/gookit/color v1.5.4
+	github.com/spaolacci/murmur3 v1.1.0
+	github.com/urfave/cli/v2 v2.27.1
+)
+
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
-	github.com/gookit/color v1.5.4 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/spaolacci/murmur3 v1.1.0 // indirect
-	github.com/urfave/cli/v2 v2.27.1 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	golang.org/x/sys v0.10.0 // indirect
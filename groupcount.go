@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// GroupByCountReporter holds every match back until Close and then passes
+// them on file by file: the files with the most matches first, and within a
+// file the lines with the most matches first. Ties are broken by path and
+// by line number so the output is the same from run to run. With a
+// positive top only that many files are passed on.
+type GroupByCountReporter struct {
+	mu    sync.Mutex
+	inner Reporter
+	top   int
+	files map[string]*matchGroup
+}
+
+// matchGroup is the matches of one file, in the order they were reported,
+// with the number of matches on each line.
+type matchGroup struct {
+	path    string
+	matches []Match
+	perLine map[int]int
+	total   int
+}
+
+// NewGroupByCountReporter wraps inner for --group-by-count.
+func NewGroupByCountReporter(inner Reporter, top int) *GroupByCountReporter {
+	return &GroupByCountReporter{inner: inner, top: top, files: make(map[string]*matchGroup)}
+}
+
+func (r *GroupByCountReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g := r.files[m.Path]
+	if g == nil {
+		g = &matchGroup{path: m.Path, perLine: make(map[int]int)}
+		r.files[m.Path] = g
+	}
+	g.matches = append(g.matches, m)
+	g.perLine[m.Line] += len(m.Spans)
+	g.total += len(m.Spans)
+}
+
+// Count is never called: --group-by-count is not a counting mode.
+func (r *GroupByCountReporter) Count(path string, n int) {}
+
+func (r *GroupByCountReporter) Close() error {
+	r.mu.Lock()
+	groups := make([]*matchGroup, 0, len(r.files))
+	for _, g := range r.files {
+		groups = append(groups, g)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].total != groups[j].total {
+			return groups[i].total > groups[j].total
+		}
+		return groups[i].path < groups[j].path
+	})
+	if r.top > 0 && len(groups) > r.top {
+		groups = groups[:r.top]
+	}
+	for _, g := range groups {
+		// The sort is stable so the matches of a line, e.g. with
+		// --only-matching, keep their order.
+		sort.SliceStable(g.matches, func(i, j int) bool {
+			a, b := g.matches[i], g.matches[j]
+			if g.perLine[a.Line] != g.perLine[b.Line] {
+				return g.perLine[a.Line] > g.perLine[b.Line]
+			}
+			return a.Line < b.Line
+		})
+		for _, m := range g.matches {
+			r.inner.Match(m)
+		}
+	}
+	return r.inner.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cutLines returns the prefix of buf holding its first n lines ending in
+// delim. An unterminated final line counts as a line.
+func cutLines(buf []byte, n int, delim byte) []byte {
+	end := 0
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(buf[end:], delim)
+		if i < 0 {
+			return buf
+		}
+		end += i + 1
+	}
+	return buf[:end]
+}
+
+// readTail reads reader to the end, keeping only its last n lines in a ring
+// buffer, and returns them as a single chunk with the absolute line number
+// and byte offset of the first kept line.
+func readTail(ctx context.Context, reader *bufio.Reader, n int, delim byte) (chunk, error) {
+	type tailLine struct {
+		data   []byte
+		offset int64
+	}
+	ring := make([]tailLine, 0, n)
+	next := 0
+	lines := 0
+	var offset int64
+	for {
+		if lines%cancelCheckLines == 0 && ctx.Err() != nil {
+			return chunk{}, ctx.Err()
+		}
+		line, err := reader.ReadBytes(delim)
+		if len(line) > 0 {
+			l := tailLine{data: line, offset: offset}
+			if len(ring) < n {
+				ring = append(ring, l)
+			} else {
+				ring[next] = l
+				next = (next + 1) % n
+			}
+			lines++
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return chunk{}, err
+		}
+	}
+
+	c := chunk{startLine: lines - len(ring) + 1}
+	if len(ring) == 0 {
+		return c, nil
+	}
+	c.offset = ring[next].offset
+	for i := range ring {
+		c.data = append(c.data, ring[(next+i)%len(ring)].data...)
+	}
+	return c, nil
+}
+
+// parseLineRange parses a --lines range: START-END, START- for the lines from
+// START to the end of the file, -END for the first END lines, or a single
+// line number. It returns the first line and the last one, or zero when the
+// range is open at the end.
+func parseLineRange(s string) (int, int, error) {
+	invalid := fmt.Errorf("invalid --lines %q (expected START-END, START-, -END or a line number, counting from 1)", s)
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		to = from
+	}
+	first, last := 1, 0
+	var err error
+	if from != "" {
+		if first, err = strconv.Atoi(from); err != nil || first < 1 {
+			return 0, 0, invalid
+		}
+	}
+	if to != "" {
+		if last, err = strconv.Atoi(to); err != nil || last < 1 {
+			return 0, 0, invalid
+		}
+	}
+	if from == "" && to == "" {
+		return 0, 0, invalid
+	}
+	if last > 0 && last < first {
+		return 0, 0, fmt.Errorf("invalid --lines %q: the range ends before it starts", s)
+	}
+	return first, last, nil
+}
+
+// skipLines drops the lines of buf that come before line from, given that
+// buf starts at line lineNum, and returns what is left together with the
+// number of lines and bytes dropped. The rest is moved to the front of buf
+// so the pooled buffer keeps its capacity.
+func skipLines(buf []byte, lineNum, from int, delim byte) ([]byte, int, int) {
+	if lineNum >= from {
+		return buf, 0, 0
+	}
+	cut := cutLines(buf, from-lineNum, delim)
+	lines, size := bytes.Count(cut, []byte{delim}), len(cut)
+	n := copy(buf, buf[size:])
+	return buf[:n], lines, size
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ProjectIgnoreFile is read from the search root when present. It uses the
+// same syntax as .gitignore but only affects findme.
+const ProjectIgnoreFile = ".findmeignore"
+
+// globalGitIgnoreFile returns the user's global git excludes file, as git
+// finds it: core.excludesFile when set, else $XDG_CONFIG_HOME/git/ignore or
+// ~/.config/git/ignore. It returns "" when there is none to look for.
+func globalGitIgnoreFile() string {
+	// --path expands a leading ~ as git does. Without git, or with the
+	// setting unset, the default location is still honoured.
+	if out, err := exec.Command("git", "config", "--path", "core.excludesFile").Output(); err == nil {
+		if name := strings.TrimSpace(string(out)); name != "" {
+			return name
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// ignorePattern is one line of a gitignore-style pattern file. base is the
+// directory the pattern is relative to; an empty base means the root of
+// whichever tree is being walked.
+type ignorePattern struct {
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// IgnoreMatcher decides whether a path below the search root is ignored. It
+// implements the gitignore rules: blank lines and '#' comments are skipped,
+// '!' re-includes a path, a trailing '/' only matches directories, a pattern
+// containing '/' is anchored to the root, and '**' matches any number of
+// directories. The last matching pattern wins.
+type IgnoreMatcher struct {
+	patterns []ignorePattern
+}
+
+// NewIgnoreMatcher returns an empty matcher.
+func NewIgnoreMatcher() *IgnoreMatcher {
+	return &IgnoreMatcher{}
+}
+
+// AddFile adds every pattern of the file at name, relative to the root of
+// every walked tree.
+func (m *IgnoreMatcher) AddFile(name string) error {
+	return m.AddFileAt(name, "")
+}
+
+// AddFileAt adds every pattern of the file at name, relative to the
+// directory base.
+func (m *IgnoreMatcher) AddFileAt(name, base string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		m.addPattern(scanner.Text(), base)
+	}
+	return scanner.Err()
+}
+
+// AddPattern adds a single pattern line relative to the walked root.
+func (m *IgnoreMatcher) AddPattern(line string) {
+	m.addPattern(line, "")
+}
+
+func (m *IgnoreMatcher) addPattern(line, base string) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return
+	}
+
+	// A pattern without an inner slash matches at any depth.
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	m.patterns = append(m.patterns, p)
+}
+
+// Empty reports whether the matcher has no patterns.
+func (m *IgnoreMatcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// Match reports whether rel, a path relative to the walked root, is ignored.
+func (m *IgnoreMatcher) Match(root, rel string, isDir bool) bool {
+	if m.Empty() {
+		return false
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		ps := segments
+		if p.base != "" {
+			var ok bool
+			if ps, ok = relSegments(p.base, filepath.Join(root, rel)); !ok {
+				continue
+			}
+		}
+		if matchSegments(p.segments, ps) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// relSegments splits the path of name relative to base, reporting false when
+// name is not below base.
+func relSegments(base, name string) ([]string, bool) {
+	rel, err := filepath.Rel(base, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, false
+	}
+	return strings.Split(filepath.ToSlash(rel), "/"), true
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
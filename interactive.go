@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gookit/color"
+)
+
+// confirmation is the state of --replace-interactive. Prompts of concurrent
+// readers must not interleave, so a file holds mu while it is being
+// confirmed.
+type confirmation struct {
+	mu      sync.Mutex
+	answers *bufio.Reader
+	out     io.Writer
+	all     bool
+	quit    bool
+}
+
+func newConfirmation(in io.Reader, out io.Writer) *confirmation {
+	return &confirmation{answers: bufio.NewReader(in), out: out}
+}
+
+// confirm shows one proposed change and asks whether to apply it: y applies
+// it, n skips it, a applies it and every later change without asking, and q
+// stops. Once q is given, or the input ends, confirm keeps returning false
+// and stopped reports true. Callers hold mu.
+func (c *confirmation) confirm(fileName string, lineNum int, before, after string) bool {
+	if c.quit {
+		return false
+	}
+	if c.all {
+		return true
+	}
+
+	fmt.Fprintf(c.out, "%s:%d\n", displayPath(fileName), lineNum)
+	fmt.Fprintln(c.out, color.Red.Sprintf("- %s", before))
+	fmt.Fprintln(c.out, color.Green.Sprintf("+ %s", after))
+	for {
+		fmt.Fprint(c.out, "Apply this change [y,n,a,q,?]? ")
+		answer, err := c.answers.ReadString('\n')
+		if err != nil && answer == "" {
+			// The input is closed; nothing more can be confirmed.
+			fmt.Fprintln(c.out)
+			c.quit = true
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "a", "all":
+			c.all = true
+			return true
+		case "q", "quit":
+			c.quit = true
+			return false
+		default:
+			fmt.Fprintln(c.out, "y - apply this change")
+			fmt.Fprintln(c.out, "n - skip this change")
+			fmt.Fprintln(c.out, "a - apply this and all later changes")
+			fmt.Fprintln(c.out, "q - quit; the current file is left unchanged")
+		}
+	}
+}
+
+// stopped reports whether the user quit. Callers hold mu.
+func (c *confirmation) stopped() bool {
+	return c.quit
+}
@@ -0,0 +1,47 @@
+package main
+
+import "unicode/utf8"
+
+// lineLengths bounds the length of the lines a search considers, for
+// --min-line-length and --max-line-length, and with dropInvalid, set by
+// --drop-invalid, also leaves out the lines that are not valid UTF-8. Lines
+// outside the bounds are skipped before the query runs on them, so they
+// neither match nor, with --invert-match, get selected. Lengths are counted
+// in the --column-unit. The methods accept a nil *lineLengths, which lets
+// every line through.
+type lineLengths struct {
+	min, max    int // max is 0 without an upper bound
+	runes       bool
+	dropInvalid bool
+}
+
+// newLineLengths returns the bounds, or nil when none is set.
+func newLineLengths(min, max int, unit string, dropInvalid bool) *lineLengths {
+	if min <= 0 && max <= 0 && !dropInvalid {
+		return nil
+	}
+	return &lineLengths{min: min, max: max, runes: unit == ColumnRune, dropInvalid: dropInvalid}
+}
+
+// fits reports whether line, without its ending, is within the bounds.
+func (l *lineLengths) fits(line []byte) bool {
+	if l == nil {
+		return true
+	}
+	if l.dropInvalid && !utf8.Valid(line) {
+		return false
+	}
+	n := len(line)
+	if l.runes {
+		// A line has at most as many runes as bytes, so the byte
+		// length settles many lines without decoding them.
+		if n < l.min {
+			return false
+		}
+		if l.min == 0 && (l.max == 0 || n <= l.max) {
+			return true
+		}
+		n = utf8.RuneCount(line)
+	}
+	return n >= l.min && (l.max == 0 || n <= l.max)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLineLengthsFits(t *testing.T) {
+	tests := []struct {
+		name    string
+		lengths *lineLengths
+		line    string
+		want    bool
+	}{
+		{"no bounds", nil, "\xff anything", true},
+		{"min", newLineLengths(3, 0, ColumnByte, false), "ab", false},
+		{"min reached", newLineLengths(3, 0, ColumnByte, false), "abc", true},
+		{"max", newLineLengths(0, 3, ColumnByte, false), "abcd", false},
+		{"max bytes", newLineLengths(0, 3, ColumnByte, false), "été", false},
+		{"max runes", newLineLengths(0, 3, ColumnRune, false), "été", true},
+		{"min runes", newLineLengths(4, 0, ColumnRune, false), "été", false},
+		{"valid", newLineLengths(0, 0, ColumnByte, true), "été", true},
+		{"invalid", newLineLengths(0, 0, ColumnByte, true), "caf\xe9", false},
+		{"truncated", newLineLengths(0, 0, ColumnByte, true), "\xc3", false},
+		{"invalid kept", newLineLengths(0, 10, ColumnByte, false), "caf\xe9", true},
+	}
+	for _, tt := range tests {
+		if got := tt.lengths.fits([]byte(tt.line)); got != tt.want {
+			t.Errorf("%s: fits(%q) = %v, want %v", tt.name, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDropInvalid(t *testing.T) {
+	const text = "needle one\ncaf\xe9 needle\nneedle \xff\xfe\nplain\nneedle two\n"
+	drop := func(edit func(*SearchOptions)) func(*SearchOptions) {
+		return func(o *SearchOptions) {
+			o.LineLengths = newLineLengths(0, 0, ColumnByte, true)
+			if edit != nil {
+				edit(o)
+			}
+		}
+	}
+	tests := []struct {
+		name  string
+		query string
+		edit  func(*SearchOptions)
+		lines []int
+	}{
+		// The plain literal is searched over whole chunks, the others
+		// line by line.
+		{"literal", "needle", nil, []int{1, 5}},
+		{"ignore case", "NEEDLE", func(o *SearchOptions) { o.CaseInsensitive = true }, []int{1, 5}},
+		{"regex", `ne+dle`, func(o *SearchOptions) { o.Regex = true }, []int{1, 5}},
+		{"invert", "needle", func(o *SearchOptions) { o.Invert = true }, []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, rep := testOptions(t, tt.query, drop(tt.edit))
+			var lines []int
+			for _, m := range processText(t, text, opts, rep) {
+				lines = append(lines, m.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("matched lines %v, want %v", lines, tt.lines)
+			}
+
+			opts, rep = testOptions(t, tt.query, drop(func(o *SearchOptions) {
+				if tt.edit != nil {
+					tt.edit(o)
+				}
+				o.CountMode = CountLines
+			}))
+			processText(t, text, opts, rep)
+			if rep.counts["f"] != len(tt.lines) {
+				t.Errorf("counted %d lines, want %d", rep.counts["f"], len(tt.lines))
+			}
+		})
+	}
+
+	path := filepath.Join(writeTree(t, map[string]string{"a.txt": text}), "a.txt")
+	rp := testReplacer(t, "needle", ReplaceOptions{Replacement: "pin"}, drop(nil))
+	if err := rp.ReplaceFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "pin one\ncaf\xe9 needle\nneedle \xff\xfe\nplain\npin two\n")
+}
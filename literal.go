@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+)
+
+// chunkLiteral returns the query when chunks can be searched as a whole with
+// bytes.Index instead of line by line: a case-sensitive literal search that
+// selects the matching lines, for a query that cannot span a line ending.
+// Otherwise it returns nil.
+func chunkLiteral(opts *SearchOptions) []byte {
+	m, ok := opts.Matcher.(*LiteralMatcher)
+	if !ok || m.Fold || opts.Invert || len(m.Query) == 0 {
+		return nil
+	}
+	if bytes.IndexByte(m.Query, opts.Delimiter) >= 0 || bytes.IndexByte(m.Query, '\r') >= 0 {
+		return nil
+	}
+	return m.Query
+}
+
+// scanHitLines calls fn, in order, for every line of c that contains query,
+// with its number, its offset in the file and its content without the line
+// ending, until fn returns false. Lines without a hit are skipped by
+// bytes.Index and never split or copied, which makes sparse matches in large
+// files cheap.
+func scanHitLines(ctx context.Context, c chunk, query []byte, delim byte, fn func(lineNum int, offset int64, record []byte) bool) {
+	data := c.data
+	// from is always the start of a line, the one numbered lineNum.
+	lineNum := c.startLine
+	for from, hits := 0, 1; from < len(data); hits++ {
+		if hits%cancelCheckLines == 0 && ctx.Err() != nil {
+			return
+		}
+		i := bytes.Index(data[from:], query)
+		if i < 0 {
+			return
+		}
+		i += from
+
+		start := from
+		if j := bytes.LastIndexByte(data[from:i], delim); j >= 0 {
+			start = from + j + 1
+		}
+		lineNum += bytes.Count(data[from:start], []byte{delim})
+		end := len(data)
+		if k := bytes.IndexByte(data[i:], delim); k >= 0 {
+			end = i + k
+		}
+
+		if !fn(lineNum, c.offset+int64(start), trimRecord(data[start:end], delim)) {
+			return
+		}
+		from = end + 1
+		lineNum++
+	}
+}
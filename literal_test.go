@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestScanHitLines(t *testing.T) {
+	type hit struct {
+		line   int
+		offset int64
+		record string
+	}
+	tests := []struct {
+		name  string
+		data  string
+		delim byte
+		stop  int
+		want  []hit
+	}{
+		{"lines", "a\nfoo\nb\nxfoo foo\n", '\n', 0, []hit{{11, 2, "foo"}, {13, 8, "xfoo foo"}}},
+		{"unterminated last line", "foo\nbar\nbarfoo", '\n', 0, []hit{{10, 0, "foo"}, {12, 8, "barfoo"}}},
+		{"crlf", "a\r\nfoo\r\n", '\n', 0, []hit{{11, 3, "foo"}}},
+		{"delimiter", "a\x00foo\x00", 0, 0, []hit{{11, 2, "foo"}}},
+		{"stop", "foo\nfoo\nfoo\n", '\n', 2, []hit{{10, 0, "foo"}, {11, 4, "foo"}}},
+		{"none", "a\nb\n", '\n', 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []hit
+			c := chunk{data: []byte(tt.data), startLine: 10}
+			scanHitLines(context.Background(), c, []byte("foo"), tt.delim, func(lineNum int, offset int64, record []byte) bool {
+				got = append(got, hit{lineNum, offset, string(record)})
+				return tt.stop == 0 || len(got) < tt.stop
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiteralChunkPath(t *testing.T) {
+	var text bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&text, "line %d\r\n", i)
+		if i%300 == 0 {
+			text.WriteString("a needle here\n")
+		}
+	}
+	chunked, rep := testOptions(t, "needle", nil)
+	if chunkLiteral(chunked) == nil {
+		t.Fatal("a plain literal search does not take the chunk path")
+	}
+	got := processText(t, text.String(), chunked, rep)
+
+	// Wrapped, the same matcher is run on every line.
+	lineByLine, rep := testOptions(t, "needle", nil)
+	lineByLine.Matcher = &PredicateMatcher{Matcher: lineByLine.Matcher}
+	if chunkLiteral(lineByLine) != nil {
+		t.Fatal("a wrapped matcher takes the chunk path")
+	}
+	if want := processText(t, text.String(), lineByLine, rep); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunk path reported\n%v\nline by line\n%v", got, want)
+	}
+}
+
+// BenchmarkLiteral compares a sparse literal search over whole chunks
+// with the same search line by line.
+func BenchmarkLiteral(b *testing.B) {
+	data := benchText(8 << 20)
+	for _, bb := range []struct {
+		name  string
+		chunk bool
+	}{
+		{"chunk", true},
+		{"lines", false},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			opts, _ := testOptions(b, "request 4242 ", func(o *SearchOptions) { o.Reporter = discardReporter{} })
+			if !bb.chunk {
+				opts.Matcher = &PredicateMatcher{Matcher: opts.Matcher}
+			}
+			benchProcess(b, data, opts)
+		})
+	}
+}
@@ -4,209 +4,1426 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
-	"github.com/gookit/color"
-	"github.com/spaolacci/murmur3"
 	"github.com/urfave/cli/v2"
 )
 
-type FileWalkerType int
-
-const (
-	Current FileWalkerType = iota
-	Recursive
-)
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-type FileWalker interface {
-	List(dir string, query string, regex bool, r *regexp.Regexp, caseInsensitive, wholeWord bool)
+	err := newApp().RunContext(ctx, os.Args)
+	if errors.Is(err, context.Canceled) {
+		// Interrupted by SIGINT; partial results have already been flushed.
+		os.Exit(130)
+	}
+	var exit *exitError
+	if errors.As(err, &exit) {
+		if !exit.silent {
+			log.Print(exit.err)
+		}
+		os.Exit(exit.code)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-type CurrentFolderWalker struct{}
+// newApp returns the command line application. Every call has its own
+// flag variables, so a test can run it more than once.
+func newApp() *cli.App {
+	var dirPath, undoDir, filesFrom, pathSeparator, patternsFile, query, replacement, replaceBackup, format, workers, columnUnit, wordBoundary, wordChars, replaceScope, conflict, colorWhen, lineDelimiter, outputPath, replaceOrder, lineRange string
+	var fileTimeout time.Duration
+	var withFilename, noFilename, count, filesWithMatches, filesWithoutMatch, countTotal, invert, searchZip, orderedByFile, events, multiline, allowOutside, follow, onlyMatching, unique, uniquePerFile, replaceInteractive, replaceDryRunJSON, searchGit, gitTracked, gitUntracked, absolutePath, fsync, includeZero, nullList, sortFiles, summaryJSON, allowDuplicateFiles, noMessages, quiet, heading, pretty, matchWholeFile, groupByCount, verifyReplace, bufferPerFile, noUndo, text, outputGzip, contextScope, ignoreGlobal, yes, dropInvalid, preserveTimestamps, noHeader bool
+	var isRegex, fixedStrings, isRecursive, caseInsensitive, wholeWord, lineNumber, column, byteOffset, maxColumnsPreview, replacePerLine, dryRun, force bool
+	var maxBytes int64
+	var threadsCPU, maxDepth, minLineLength, maxLineLength int
+	var maxColumns, maxMatchesPerLine, maxCount, replaceCount, replacePreviewLimit, confirmThreshold, head, tail, fuzzy, outputBufferSize, top, previewLines int
+	var ignoreFiles, include, exclude, excludeDir, includeFrom, excludeFrom, andPatterns, notPatterns, colors, replacePairArgs, scopePatterns cli.StringSlice
+
+	// -h is taken by --no-filename, as in grep, so help is only --help.
+	cli.HelpFlag = &cli.BoolFlag{Name: "help", Usage: "show help"}
+
+	return &cli.App{
+		Commands: []*cli.Command{
+			{
+				Name:  "search",
+				Usage: "Search files in a directory",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "dir",
+						Aliases:     []string{"d"},
+						Usage:       "Directory or file to search in; globs such as 'src/**/handlers' are expanded",
+						Destination: &dirPath,
+					},
+					&cli.StringFlag{
+						Name:        "files-from",
+						Usage:       "Search exactly the files listed in this file, one per line, instead of walking --dir; - reads the list from stdin",
+						Destination: &filesFrom,
+					},
+					&cli.BoolFlag{
+						Name:        "null",
+						Usage:       "With --files-from, the names are separated by NUL, as printed by find -print0 or git ls-files -z",
+						Destination: &nullList,
+					},
+					&cli.StringFlag{
+						Name:        "query",
+						Aliases:     []string{"q"},
+						Usage:       "Search query",
+						Destination: &query,
+					},
+					&cli.StringFlag{
+						Name:        "patterns-file",
+						Aliases:     []string{"f"},
+						Usage:       "Also search for every pattern in this file, one per line; lines matching any of them are reported",
+						Destination: &patternsFile,
+					},
+					&cli.StringSliceFlag{
+						Name:        "and",
+						Usage:       "Also require this pattern to match the line (repeatable)",
+						Destination: &andPatterns,
+					},
+					&cli.StringSliceFlag{
+						Name:        "not",
+						Usage:       "Require this pattern not to match the line (repeatable)",
+						Destination: &notPatterns,
+					},
+					&cli.BoolFlag{
+						Name:        "regex",
+						Aliases:     []string{"r"},
+						Usage:       "Use regular expression for search",
+						Destination: &isRegex,
+					},
+					&cli.BoolFlag{
+						Name:        "fixed-strings",
+						Aliases:     []string{"F"},
+						Usage:       "Search for the query as plain text, even when --regex is given",
+						Destination: &fixedStrings,
+					},
+					&cli.BoolFlag{
+						Name:        "recursive",
+						Aliases:     []string{"R"},
+						Usage:       "Search recursively in subdirectories; the same as an unlimited --max-depth",
+						Destination: &isRecursive,
+					},
+					&cli.IntFlag{
+						Name:        "max-depth",
+						Usage:       "Descend at most N directory levels below each root; 0 searches only the files directly inside it (the default without --recursive)",
+						Destination: &maxDepth,
+					},
+					&cli.BoolFlag{
+						Name:        "case-insensitive",
+						Aliases:     []string{"i"},
+						Usage:       "Perform case-insensitive search",
+						Destination: &caseInsensitive,
+					},
+					&cli.BoolFlag{
+						Name:        "whole-word",
+						Aliases:     []string{"w"},
+						Usage:       "Match whole words only",
+						Destination: &wholeWord,
+					},
+					&cli.StringFlag{
+						Name:        "word-boundary",
+						Usage:       "What --whole-word treats as a word: default (regexp \\b), identifier (letters, digits and _ around the match) or custom (--word-chars)",
+						Value:       WordBoundaryDefault,
+						Destination: &wordBoundary,
+					},
+					&cli.StringFlag{
+						Name:        "word-chars",
+						Usage:       "Word characters of --word-boundary custom as a character class, e.g. 'A-Za-z0-9_$-'",
+						Destination: &wordChars,
+					},
+					&cli.BoolFlag{
+						Name:        "multiline",
+						Usage:       "Let --regex matches span lines; . also matches newlines (reads whole files, up to 64 MiB)",
+						Destination: &multiline,
+					},
+					&cli.BoolFlag{
+						Name:        "match-whole-file",
+						Usage:       "List the files whose entire content matches the query, or with --invert-match those whose content does not (reads whole files, up to 64 MiB)",
+						Destination: &matchWholeFile,
+					},
+					&cli.IntFlag{
+						Name:        "fuzzy",
+						Usage:       "Match the query with up to K typos (insertions, deletions or substitutions)",
+						Destination: &fuzzy,
+					},
+					&cli.BoolFlag{
+						Name:        "invert-match",
+						Aliases:     []string{"v"},
+						Usage:       "Select lines that do not match",
+						Destination: &invert,
+					},
+					&cli.BoolFlag{
+						Name:        "only-matching",
+						Aliases:     []string{"o"},
+						Usage:       "Print only the matched parts of each line, one per output line",
+						Destination: &onlyMatching,
+					},
+					&cli.IntFlag{
+						Name:        "max-matches-per-line",
+						Usage:       "With --only-matching, print at most N matches of each line (0 means no limit)",
+						Destination: &maxMatchesPerLine,
+					},
+					&cli.BoolFlag{
+						Name:        "unique",
+						Usage:       "Print each distinct matching line (or match, with --only-matching) only once across all files",
+						Destination: &unique,
+					},
+					&cli.BoolFlag{
+						Name:        "unique-per-file",
+						Usage:       "Like --unique, but only drop repeats within the same file",
+						Destination: &uniquePerFile,
+					},
+					&cli.BoolFlag{
+						Name:        "line-number",
+						Aliases:     []string{"n"},
+						Usage:       "Print the line number of each match",
+						Destination: &lineNumber,
+					},
+					&cli.BoolFlag{
+						Name:        "column",
+						Usage:       "Print the column of the first match on each line (implies --line-number)",
+						Destination: &column,
+					},
+					&cli.StringFlag{
+						Name:        "column-unit",
+						Usage:       "Count columns in bytes, as grep does, or in runes (characters): byte or rune",
+						Value:       ColumnByte,
+						Destination: &columnUnit,
+					},
+					&cli.BoolFlag{
+						Name:        "with-filename",
+						Aliases:     []string{"H"},
+						Usage:       "Print the file name for each match (default when searching a directory)",
+						Destination: &withFilename,
+					},
+					&cli.BoolFlag{
+						Name:        "no-filename",
+						Aliases:     []string{"h"},
+						Usage:       "Never print file names (default when searching a single file)",
+						Destination: &noFilename,
+					},
+					&cli.BoolFlag{
+						Name:        "absolute-path",
+						Usage:       "Print absolute file names instead of paths relative to the search directory, also for the names of --files-from and archive entries",
+						Destination: &absolutePath,
+					},
+					&cli.StringFlag{
+						Name:        "path-separator",
+						Usage:       "Separator to print in file names instead of the native one, e.g. / on Windows",
+						Destination: &pathSeparator,
+					},
+					&cli.BoolFlag{
+						Name:        "byte-offset",
+						Aliases:     []string{"b"},
+						Usage:       "Print the byte offset within the file where the first match on each line begins",
+						Destination: &byteOffset,
+					},
+					&cli.StringSliceFlag{
+						Name:        "include",
+						Usage:       "Only search files whose name matches the glob (repeatable)",
+						Destination: &include,
+					},
+					&cli.StringSliceFlag{
+						Name:        "exclude",
+						Usage:       "Skip files whose name matches the glob (repeatable)",
+						Destination: &exclude,
+					},
+					&cli.StringSliceFlag{
+						Name:        "include-from",
+						Usage:       "Also only search files matching the globs in this file, one per line (repeatable)",
+						Destination: &includeFrom,
+					},
+					&cli.StringSliceFlag{
+						Name:        "exclude-from",
+						Usage:       "Also skip files matching the globs in this file, one per line (repeatable)",
+						Destination: &excludeFrom,
+					},
+					&cli.StringSliceFlag{
+						Name:        "exclude-dir",
+						Usage:       "Do not descend into directories whose name matches the glob, e.g. node_modules (repeatable)",
+						Destination: &excludeDir,
+					},
+					&cli.BoolFlag{
+						Name:        "search-zip",
+						Usage:       "Search inside .zip archives, reporting matches as archive.zip!entry",
+						Destination: &searchZip,
+					},
+					&cli.BoolFlag{
+						Name:        "text",
+						Aliases:     []string{"a"},
+						Usage:       "Search every file as text, including --search-zip entries that contain NUL bytes and are otherwise skipped as binary",
+						Destination: &text,
+					},
+					&cli.BoolFlag{
+						Name:        "search-git",
+						Usage:       "Also search the .git directories, which are skipped by default",
+						Destination: &searchGit,
+					},
+					&cli.BoolFlag{
+						Name:        "allow-duplicate-files",
+						Usage:       "Search a file each time it is reached, e.g. through a symlink or from two roots, instead of once",
+						Destination: &allowDuplicateFiles,
+					},
+					&cli.BoolFlag{
+						Name:        "git-tracked",
+						Usage:       "Search only the files git tracks, as listed by git ls-files, instead of walking every file",
+						Destination: &gitTracked,
+					},
+					&cli.BoolFlag{
+						Name:        "git-untracked",
+						Usage:       "With --git-tracked, also search untracked files that are not ignored",
+						Destination: &gitUntracked,
+					},
+					&cli.StringSliceFlag{
+						Name:        "ignore-file",
+						Usage:       "Skip paths matching the gitignore-style patterns in this file (repeatable)",
+						Destination: &ignoreFiles,
+					},
+					&cli.BoolFlag{
+						Name:        "respect-ignore-global",
+						Usage:       "Also skip paths matching the user's global gitignore (core.excludesFile or ~/.config/git/ignore)",
+						Destination: &ignoreGlobal,
+					},
+					&cli.IntFlag{
+						Name:        "max-columns",
+						Aliases:     []string{"M"},
+						Usage:       "Truncate printed lines longer than N characters (0 means no limit)",
+						Destination: &maxColumns,
+					},
+					&cli.BoolFlag{
+						Name:        "max-columns-preview",
+						Usage:       "With --max-columns, print a window around the first match instead of the start of the line",
+						Destination: &maxColumnsPreview,
+					},
+					&cli.StringFlag{
+						Name:        "color",
+						Value:       ColorAuto,
+						Usage:       "When to color the text output: auto colors it only on a terminal, always also in pipes and files, never not at all",
+						Destination: &colorWhen,
+					},
+					&cli.StringSliceFlag{
+						Name:        "colors",
+						Usage:       "Change a color of the text output, as type:fg:color, type:bg:color, type:style:option or type:none with type path, line, column or match (repeatable)",
+						Destination: &colors,
+					},
+					&cli.IntFlag{
+						Name:        "threads-cpu",
+						Usage:       "Match at most N chunks at once across all files; the readers of --workers only open and read files (0 means one matcher per CPU for every file)",
+						Destination: &threadsCPU,
+					},
+					&cli.StringFlag{
+						Name:        "workers",
+						Aliases:     []string{"j", "threads-io"},
+						Usage:       "Number of files read in parallel, or auto to adapt to the workload",
+						Value:       "auto",
+						Destination: &workers,
+					},
+					&cli.StringFlag{
+						Name:        "line-delimiter",
+						Usage:       "Split records on this character instead of newlines, e.g. '\\0' for find -print0 output; line numbers count records",
+						Destination: &lineDelimiter,
+					},
+					&cli.IntFlag{
+						Name:        "head",
+						Usage:       "Only search the first N lines of each file",
+						Destination: &head,
+					},
+					&cli.StringFlag{
+						Name:        "lines",
+						Usage:       "Only search the lines START-END of each file, e.g. 100-200, 100- or -50; line numbers stay those of the file",
+						Destination: &lineRange,
+					},
+					&cli.IntFlag{
+						Name:        "tail",
+						Usage:       "Only search the last N lines of each file",
+						Destination: &tail,
+					},
+					&cli.DurationFlag{
+						Name:        "timeout-per-file",
+						Usage:       "Give up on a file after this long, e.g. 5s, and move on to the next one",
+						Destination: &fileTimeout,
+					},
+					&cli.BoolFlag{
+						Name:        "follow",
+						Usage:       "Keep watching the files, like tail -f, and print matching lines as they are appended; new files in a directory are searched too",
+						Destination: &follow,
+					},
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "Output format: text, json (a single array), jsonl (one object per line), or csv or tsv (a row per match, with a column per named group of --regex)",
+						Value:       FormatText,
+						Destination: &format,
+					},
+					&cli.BoolFlag{
+						Name:        "no-header",
+						Usage:       "Leave out the header row of --format csv or tsv",
+						Destination: &noHeader,
+					},
+					&cli.BoolFlag{
+						Name:        "context-scope",
+						Usage:       "Show the line opening the function, class or section around each match, found by a pattern for the file's extension",
+						Destination: &contextScope,
+					},
+					&cli.StringSliceFlag{
+						Name:        "scope-pattern",
+						Usage:       "Use REGEX to find the scope lines of files with extension EXT for --context-scope, given as EXT=REGEX (repeatable; an empty REGEX turns scopes off for EXT)",
+						Destination: &scopePatterns,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Usage:       "Write the results to FILE instead of standard output, without colors; the file itself is not searched",
+						Destination: &outputPath,
+					},
+					&cli.BoolFlag{
+						Name:        "output-gzip",
+						Usage:       "Compress the --output file with gzip",
+						Destination: &outputGzip,
+					},
+					&cli.IntFlag{
+						Name:        "output-buffer-size",
+						Usage:       "Buffer up to N bytes of output between writes (default 65536, or 0 on a terminal; 0 disables)",
+						Destination: &outputBufferSize,
+						DefaultText: "auto",
+					},
+					&cli.BoolFlag{
+						Name:        "events",
+						Usage:       "Stream begin, match, end and summary events as JSON Lines for editors and other tools",
+						Destination: &events,
+					},
+					&cli.BoolFlag{
+						Name:        "count",
+						Aliases:     []string{"c"},
+						Usage:       "Print only the number of matching lines of each file, as path:N on a line per file",
+						Destination: &count,
+					},
+					&cli.BoolFlag{
+						Name:        "include-zero",
+						Usage:       "With --count, also list the files without matches",
+						Destination: &includeZero,
+					},
+					&cli.BoolFlag{
+						Name:        "files-with-matches",
+						Aliases:     []string{"l"},
+						Usage:       "Print only the names of files with at least one match",
+						Destination: &filesWithMatches,
+					},
+					&cli.BoolFlag{
+						Name:        "files-without-match",
+						Aliases:     []string{"L"},
+						Usage:       "Print only the names of files without any match",
+						Destination: &filesWithoutMatch,
+					},
+					&cli.BoolFlag{
+						Name:        "count-total",
+						Usage:       "Print only one number, the matching lines of all files together, e.g. for N=$(findme search ...)",
+						Destination: &countTotal,
+					},
+					&cli.IntFlag{
+						Name:        "top",
+						Usage:       "Print the N files with the most matching lines, most first (implies --count)",
+						Destination: &top,
+					},
+					&cli.IntFlag{
+						Name:        "min-line-length",
+						Usage:       "Skip lines shorter than N characters, counted in --column-unit, as if they were not in the file",
+						Destination: &minLineLength,
+					},
+					&cli.IntFlag{
+						Name:        "max-line-length",
+						Usage:       "Skip lines longer than N characters, e.g. minified code, as if they were not in the file (0 means no limit)",
+						Destination: &maxLineLength,
+					},
+					&cli.BoolFlag{
+						Name:        "drop-invalid",
+						Usage:       "Skip lines that are not valid UTF-8 as if they were not in the file",
+						Destination: &dropInvalid,
+					},
+					&cli.IntFlag{
+						Name:        "max-count",
+						Aliases:     []string{"m"},
+						Usage:       "Stop reading a file after N matching lines",
+						Destination: &maxCount,
+					},
+					&cli.Int64Flag{
+						Name:        "max-bytes",
+						Usage:       "Stop the whole search once N bytes have been read across all files (0 means no limit)",
+						Destination: &maxBytes,
+					},
+					&cli.BoolFlag{
+						Name:        "quiet",
+						Usage:       "Print nothing and stop at the first match; exit 0 if something matched and 1 if not (-q is --query)",
+						Destination: &quiet,
+					},
+					&cli.BoolFlag{
+						Name:        "no-messages",
+						Aliases:     []string{"s"},
+						Usage:       "Do not print errors about files that cannot be opened or read; they still make the exit status 2",
+						Destination: &noMessages,
+					},
+					&cli.BoolFlag{
+						Name:        "heading",
+						Usage:       "Print each file name once, above the matches of the file, instead of on every line",
+						Destination: &heading,
+					},
+					&cli.IntFlag{
+						Name:        "preview-lines",
+						Usage:       "With --heading, print at most N results of each file and a note of how many more there are (0 means no limit)",
+						Destination: &previewLines,
+					},
+					&cli.BoolFlag{
+						Name:        "pretty",
+						Usage:       "Shorthand for --heading, --line-number and --color always; flags given explicitly take precedence, e.g. --pretty --color never",
+						Destination: &pretty,
+					},
+					&cli.BoolFlag{
+						Name:        "group-by-count",
+						Usage:       "Print the files with the most matches first, and within each file the lines with the most matches first; with --top N only the N busiest files (holds all results until the end)",
+						Destination: &groupByCount,
+					},
+					&cli.BoolFlag{
+						Name:        "ordered-by-file",
+						Usage:       "Print all results of a file before those of files found after it",
+						Destination: &orderedByFile,
+					},
+					&cli.BoolFlag{
+						Name:        "buffer-per-file",
+						Usage:       "Print the results of each file as one block, in line order, once the file has been searched",
+						Destination: &bufferPerFile,
+					},
+					&cli.BoolFlag{
+						Name:        "sort-files",
+						Usage:       "Search one file at a time in sorted order so the output is the same on every run; slower",
+						Destination: &sortFiles,
+					},
+					&cli.BoolFlag{
+						Name:        "summary-json",
+						Usage:       "At the end, print the run totals (files, matches, bytes, duration, exit reason) to stderr as one JSON object",
+						Destination: &summaryJSON,
+					},
+					&cli.StringFlag{
+						Name:        "replace",
+						Usage:       "Replace matches in place with the given text ($1 expands capture groups in regex mode)",
+						Destination: &replacement,
+					},
+					&cli.StringSliceFlag{
+						Name:        "replace-pair",
+						Usage:       "Replace matches of OLD with NEW, given as OLD=NEW; repeatable for batch renames and used instead of --query and --replace",
+						Destination: &replacePairArgs,
+					},
+					&cli.StringFlag{
+						Name:        "replace-order",
+						Usage:       "How --replace-pair pairs combine: sequential (each rewrites the result of the ones before) or independent (all match the original text)",
+						Value:       PairsSequential,
+						Destination: &replaceOrder,
+					},
+					&cli.StringFlag{
+						Name:        "replace-only-matching",
+						Usage:       "Only replace inside the spans matched by this regular expression, e.g. 'fetch\\([^)]*\\)'",
+						Destination: &replaceScope,
+					},
+					&cli.IntFlag{
+						Name:        "replace-count",
+						Usage:       "Replace at most N matches per file (0 means all)",
+						Destination: &replaceCount,
+					},
+					&cli.BoolFlag{
+						Name:        "replace-per-line",
+						Usage:       "Apply --replace-count to each line instead of each file",
+						Destination: &replacePerLine,
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "Show the replacements that would be made without writing any file",
+						Destination: &dryRun,
+					},
+					&cli.BoolFlag{
+						Name:        "replace-interactive",
+						Usage:       "Confirm each changed line with y (yes), n (no), a (all) or q (quit) before it is written",
+						Destination: &replaceInteractive,
+					},
+					&cli.BoolFlag{
+						Name:        "replace-dry-run-json",
+						Usage:       "Like --dry-run, but print every proposed change as a single JSON document",
+						Destination: &replaceDryRunJSON,
+					},
+					&cli.IntFlag{
+						Name:        "replace-preview-limit",
+						Usage:       "With --dry-run or --replace-interactive, stop after N changed files and note that more exist (0 means no limit)",
+						Destination: &replacePreviewLimit,
+					},
+					&cli.IntFlag{
+						Name:        "confirm-threshold",
+						Usage:       "Ask before a replace writes to more than N files, counted in a preview pass first (0 never asks)",
+						Value:       defaultConfirmThreshold,
+						Destination: &confirmThreshold,
+					},
+					&cli.BoolFlag{
+						Name:        "yes",
+						Usage:       "Write the changes of a replace without asking, however many files change",
+						Destination: &yes,
+					},
+					&cli.BoolFlag{
+						Name:        "verify-replace",
+						Usage:       "Re-read every rewritten file and warn about changed lines the query still matches",
+						Destination: &verifyReplace,
+					},
+					&cli.StringFlag{
+						Name:        "conflict",
+						Value:       ConflictFirst,
+						Usage:       "When matches of different patterns overlap in a replace: first applies the leftmost, or the pattern listed first; error aborts the file",
+						Destination: &conflict,
+					},
+					&cli.StringFlag{
+						Name:        "undo-dir",
+						Usage:       "Journal the original of every rewritten file here for replace-undo (default: findme/undo in the user cache directory)",
+						Destination: &undoDir,
+					},
+					&cli.BoolFlag{
+						Name:        "no-undo",
+						Usage:       "Do not journal the originals of rewritten files; replace-undo cannot restore the run",
+						Destination: &noUndo,
+					},
+					&cli.StringFlag{
+						Name:        "replace-backup",
+						Usage:       "Keep a copy of each modified file as <name><suffix>, e.g. .bak",
+						Destination: &replaceBackup,
+					},
+					&cli.BoolFlag{
+						Name:        "allow-outside",
+						Usage:       "Let --replace modify files that resolve, e.g. through a symlink, to a path outside the search root",
+						Destination: &allowOutside,
+					},
+					&cli.BoolFlag{
+						Name:        "force",
+						Usage:       "Overwrite existing backup files",
+						Destination: &force,
+					},
+					&cli.BoolFlag{
+						Name:        "fsync",
+						Usage:       "Flush every rewritten file to disk before moving on; slower, but replacements survive a crash",
+						Destination: &fsync,
+					},
+					&cli.BoolFlag{
+						Name:        "preserve-timestamps",
+						Usage:       "Keep the modification time of every rewritten file, e.g. so a build does not see it as changed",
+						Destination: &preserveTimestamps,
+					},
+				},
+				Action: func(c *cli.Context) (err error) {
+					var stats *RunStats
+					if summaryJSON {
+						// The summary is written on every exit, even a
+						// failed setup, so stderr always holds one object.
+						stats = NewRunStats()
+						defer func() {
+							stats.writeSummary(os.Stderr, err)
+						}()
+					}
+					if err := checkFlagConflicts(c, searchFlagConflicts); err != nil {
+						return err
+					}
+
+					if pretty {
+						// The preset only fills in what was not given.
+						if !c.IsSet("heading") {
+							heading = true
+						}
+						if !c.IsSet("line-number") {
+							lineNumber = true
+						}
+						if !c.IsSet("color") {
+							colorWhen = ColorAlways
+						}
+					}
+
+					var pairs []ReplacePair
+					for _, arg := range replacePairArgs.Value() {
+						pair, err := ParseReplacePair(arg)
+						if err != nil {
+							return err
+						}
+						pairs = append(pairs, pair)
+					}
+					if c.IsSet("replace-order") && len(pairs) == 0 {
+						return fmt.Errorf("--replace-order requires --replace-pair")
+					}
+					replacing := c.IsSet("replace") || len(pairs) > 0
+					if (c.IsSet("confirm-threshold") || yes) && !replacing {
+						return fmt.Errorf("--confirm-threshold and --yes require --replace or --replace-pair")
+					}
+
+					var patterns []string
+					if c.IsSet("query") {
+						patterns = append(patterns, query)
+					}
+					if len(pairs) > 0 {
+						// The pairs are the patterns of the search.
+						for _, pair := range pairs {
+							patterns = append(patterns, pair.Old)
+						}
+					} else if patternsFile != "" {
+						filePatterns, err := readPatterns(patternsFile)
+						if err != nil {
+							return fmt.Errorf("reading patterns file: %w", err)
+						}
+						patterns = append(patterns, filePatterns...)
+					} else if !c.IsSet("query") {
+						return fmt.Errorf("--query is required unless --patterns-file or --replace-pair is given")
+					}
+					if len(patterns) == 0 {
+						return fmt.Errorf("no patterns in %s", patternsFile)
+					}
+					if fixedStrings {
+						isRegex = false
+					}
+					query = patterns[0]
+					if len(patterns) > 1 && isRegex {
+						query = regexAlternation(patterns)
+					}
+
+					var regex *regexp.Regexp
+					if isRegex {
+						pattern := query
+						if multiline {
+							// Let . match the newlines between lines.
+							pattern = "(?s)" + pattern
+						}
+						if caseInsensitive {
+							pattern = "(?i)" + pattern
+						}
+						if regex, err = compileQuery(pattern); err != nil {
+							return err
+						}
+						if query == "" {
+							fmt.Fprintln(os.Stderr, "Warning: the empty regular expression matches every line")
+						}
+					}
+
+					depth, err := searchDepth(isRecursive, maxDepth, c.IsSet("max-depth"))
+					if err != nil {
+						return err
+					}
+
+					if minLineLength < 0 || maxLineLength < 0 {
+						return fmt.Errorf("--min-line-length and --max-line-length must not be negative")
+					}
+					if maxLineLength > 0 && minLineLength > maxLineLength {
+						return fmt.Errorf("--min-line-length %d is greater than --max-line-length %d", minLineLength, maxLineLength)
+					}
+
+					opts := &SearchOptions{
+						LineLengths:       newLineLengths(minLineLength, maxLineLength, columnUnit, dropInvalid),
+						Query:             query,
+						MaxDepth:          depth,
+						Patterns:          patterns,
+						Stats:             stats,
+						Errors:            &fileErrors{quiet: noMessages},
+						Regex:             isRegex,
+						Re:                regex,
+						CaseInsensitive:   caseInsensitive,
+						WholeWord:         wholeWord,
+						Fuzzy:             fuzzy,
+						Multiline:         multiline,
+						And:               andPatterns.Value(),
+						Not:               notPatterns.Value(),
+						Invert:            invert,
+						OnlyMatching:      onlyMatching,
+						MaxMatchesPerLine: maxMatchesPerLine,
+						SearchZip:         searchZip,
+						Text:              text,
+						SearchGit:         searchGit,
+						GitTracked:        gitTracked,
+						GitUntracked:      gitUntracked,
+						LineNumber:        lineNumber,
+						Column:            column,
+						ColumnUnit:        columnUnit,
+						ByteOffset:        byteOffset,
+						MaxCount:          maxCount,
+						Head:              head,
+						Tail:              tail,
+						FileTimeout:       fileTimeout,
+						MaxColumns:        maxColumns,
+						MaxColumnsPreview: maxColumnsPreview,
+					}
+
+					var roots []string
+					stdinReplace, stdinSearch := false, false
+					if filesFrom != "" {
+						if filesFrom == "-" && replaceInteractive {
+							return fmt.Errorf("--files-from - cannot be combined with --replace-interactive, which reads the answers from stdin")
+						}
+						list, err := openFileList(filesFrom)
+						if err != nil {
+							return err
+						}
+						defer list.Close()
+						opts.FileList = list
+						opts.FileListDelimiter = '\n'
+						if nullList {
+							opts.FileListDelimiter = 0
+						}
+					} else if dirPath == "" && replacing {
+						// Without --dir, --replace filters standard
+						// input to standard output like sed.
+						if dryRun || replaceInteractive || replaceDryRunJSON || replaceBackup != "" || fsync || preserveTimestamps || follow || gitTracked {
+							return fmt.Errorf("--replace on standard input cannot be combined with --dry-run, --replace-interactive, --replace-dry-run-json, --replace-backup, --fsync, --preserve-timestamps, --follow or --git-tracked")
+						}
+						stdinReplace = true
+					} else if dirPath == "" && stdinIsPiped() {
+						// Without --dir, a piped standard input is
+						// searched as a single file.
+						if follow || gitTracked || nullList {
+							return fmt.Errorf("searching standard input cannot be combined with --follow, --git-tracked or --null")
+						}
+						stdinSearch = true
+					} else {
+						if dirPath == "" {
+							return fmt.Errorf("--dir is required unless --files-from or --replace is given or standard input is piped")
+						}
+						if nullList {
+							return fmt.Errorf("--null requires --files-from")
+						}
+						if gitUntracked && !gitTracked {
+							return fmt.Errorf("--git-untracked requires --git-tracked")
+						}
+						roots, err = ExpandRoots(dirPath)
+						if err != nil {
+							return &exitError{code: exitTrouble, err: err}
+						}
+						if err := checkRoots(roots); err != nil {
+							return err
+						}
+						if absolutePath {
+							if roots, err = absoluteRoots(roots); err != nil {
+								return err
+							}
+						}
+					}
+					opts.WithFilename = resolveWithFilename(roots, withFilename, noFilename)
+
+					numWorkers, err := ParseWorkers(workers)
+					if err != nil {
+						return err
+					}
+					opts.Workers = numWorkers
+					if threadsCPU < 0 {
+						return fmt.Errorf("--threads-cpu must not be negative")
+					}
+					if threadsCPU > 0 {
+						opts.CPUSlots = make(cpuSlots, threadsCPU)
+					}
+					if sortFiles {
+						// A single reader takes the files in the walk
+						// order, which is lexical within each root.
+						opts.Workers = 1
+						opts.SortFiles = true
+						sort.Strings(roots)
+					}
+
+					ignore, err := loadIgnoreFiles(roots, ignoreFiles.Value(), ignoreGlobal)
+					if err != nil {
+						return err
+					}
+					opts.Ignore = ignore
+
+					filter, err := newPathFilterFrom(include.Value(), exclude.Value(), excludeDir.Value(), includeFrom.Value(), excludeFrom.Value())
+					if err != nil {
+						return err
+					}
+					opts.Filter = filter
+
+					if top < 0 {
+						return fmt.Errorf("--top must not be negative")
+					}
+					if top > 0 {
+						// With --group-by-count the matches of the top
+						// files are printed instead of their counts.
+						count = !groupByCount
+						// The ranking is meaningless without the names.
+						opts.WithFilename = true
+					}
+					if quiet {
+						// A file is settled by its first match, which the
+						// counting path finds without building results.
+						filesWithMatches = true
+					}
+					countMode, err := NewCountMode(count, filesWithMatches, filesWithoutMatch, countTotal)
+					if err != nil {
+						return err
+					}
+					if matchWholeFile {
+						if opts.WholeFile, err = compileWholeFile(patterns, isRegex, caseInsensitive); err != nil {
+							return err
+						}
+						// Only the names of the files are printed.
+						if countMode == CountNone {
+							countMode = CountFilesWith
+						}
+					}
+					opts.CountMode = countMode
+					if includeZero {
+						if countMode != CountLines {
+							return fmt.Errorf("--include-zero requires --count")
+						}
+						opts.IncludeZero = true
+					}
+					if maxCount < 0 {
+						return fmt.Errorf("--max-count must not be negative")
+					}
+
+					opts.Delimiter, err = ParseLineDelimiter(lineDelimiter)
+					if err != nil {
+						return err
+					}
+					if opts.Delimiter != '\n' && (multiline || replacing) {
+						return fmt.Errorf("--line-delimiter cannot be combined with --multiline or --replace")
+					}
+
+					opts.WordChars, err = NewWordChars(wordBoundary, wordChars)
+					if err != nil {
+						return err
+					}
+
+					if fuzzy < 0 {
+						return fmt.Errorf("--fuzzy must not be negative")
+					}
+					// Checked here rather than up front, since --fixed-strings
+					// wins over --regex.
+					if fuzzy > 0 && isRegex {
+						return fmt.Errorf("--fuzzy cannot be combined with --regex")
+					}
+					if wholeWord && isRegex {
+						return fmt.Errorf("--whole-word cannot be combined with --regex: put \\b around the pattern instead")
+					}
+					if len(patterns) > 1 && !isRegex && fuzzy > 0 {
+						return fmt.Errorf("several literal patterns cannot be combined with --fuzzy")
+					}
+
+					if multiline && !isRegex {
+						return fmt.Errorf("--multiline requires --regex")
+					}
+
+					if head < 0 || tail < 0 {
+						return fmt.Errorf("--head and --tail must not be negative")
+					}
+					if lineRange != "" {
+						if opts.FromLine, opts.Head, err = parseLineRange(lineRange); err != nil {
+							return err
+						}
+					}
+
+					if columnUnit != ColumnByte && columnUnit != ColumnRune {
+						return fmt.Errorf("unknown column unit %q (expected byte or rune)", columnUnit)
+					}
+
+					if maxColumns < 0 {
+						return fmt.Errorf("--max-columns must not be negative")
+					}
+					if maxMatchesPerLine < 0 {
+						return fmt.Errorf("--max-matches-per-line must not be negative")
+					}
+
+					if maxBytes < 0 {
+						return fmt.Errorf("--max-bytes must not be negative")
+					}
+					if follow && format == FormatJSON {
+						return fmt.Errorf("--follow cannot be combined with --format json, which is written once the search is over")
+					}
+					opts.Heading = heading
+					if previewLines < 0 {
+						return fmt.Errorf("--preview-lines must not be negative")
+					}
+					if previewLines > 0 && !heading {
+						return fmt.Errorf("--preview-lines requires --heading or --pretty")
+					}
+					opts.PreviewLines = previewLines
+					if tabular := format == FormatCSV || format == FormatTSV; !tabular && noHeader {
+						return fmt.Errorf("--no-header requires --format csv or tsv")
+					} else if tabular && invert {
+						return fmt.Errorf("--format %s cannot be combined with --invert-match: an inverted line has no match to extract", format)
+					} else if tabular && regex != nil {
+						if err := checkCSVGroups(regex); err != nil {
+							return err
+						}
+					}
+					opts.NoHeader = noHeader
+					if heading && !follow {
+						// A heading needs the results of a file together.
+						orderedByFile = true
+						bufferPerFile = true
+					}
+
+					opts.Matcher, err = NewMatcher(opts)
+					if err != nil {
+						return err
+					}
+
+					if events {
+						format = FormatEvents
+					}
+
+					if outputGzip && outputPath == "" {
+						return fmt.Errorf("--output-gzip requires --output")
+					}
+					if !c.IsSet("output-buffer-size") {
+						outputBufferSize = defaultOutputBuffer(os.Stdout)
+						if outputPath != "" {
+							outputBufferSize = defaultOutputBufferSize
+						}
+					}
+					if outputBufferSize < 0 {
+						return fmt.Errorf("--output-buffer-size must not be negative")
+					}
+					var dest io.Writer = os.Stdout
+					finish := func() error { return nil }
+					if outputPath != "" {
+						if dest, finish, err = openOutput(outputPath, outputGzip); err != nil {
+							return err
+						}
+					}
+					out, flush := newOutput(dest, outputBufferSize)
+					defer func() {
+						// Also on an early return, so a file gets the
+						// buffered results and, compressed, its gzip
+						// trailer. Flushing twice is harmless.
+						ferr := flush()
+						if cerr := finish(); ferr == nil {
+							ferr = cerr
+						}
+						if err == nil && ferr != nil && outputPath != "" {
+							ferr = fmt.Errorf("writing %s: %v", outputPath, ferr)
+						}
+						if err == nil {
+							err = ferr
+						}
+					}()
+
+					if replacing {
+						if replaceCount < 0 {
+							return fmt.Errorf("--replace-count must not be negative")
+						}
+						if replaceDryRunJSON {
+							dryRun = true
+						}
+						if replacePreviewLimit < 0 {
+							return fmt.Errorf("--replace-preview-limit must not be negative")
+						}
+						if replacePreviewLimit > 0 && !dryRun && !replaceInteractive {
+							return fmt.Errorf("--replace-preview-limit requires --dry-run, --replace-dry-run-json or --replace-interactive")
+						}
+						if confirmThreshold < 0 {
+							return fmt.Errorf("--confirm-threshold must not be negative")
+						}
+						var scope *regexp.Regexp
+						if replaceScope != "" {
+							scope, err = regexp.Compile(replaceScope)
+							if err != nil {
+								return fmt.Errorf("invalid --replace-only-matching: %v", err)
+							}
+						}
+						replaceRoots := roots
+						if opts.FileList != nil {
+							// Listed paths are relative to the working
+							// directory.
+							replaceRoots = []string{"."}
+						}
+						if !noUndo && !stdinReplace && undoDir == "" {
+							if undoDir, err = defaultUndoDir(); err != nil {
+								return fmt.Errorf("no undo directory: %v (use --undo-dir or --no-undo)", err)
+							}
+						}
+						if noUndo || stdinReplace {
+							undoDir = ""
+						}
+						replacer, err := NewReplacer(opts, ReplaceOptions{
+							Replacement:        replacement,
+							Pairs:              pairs,
+							PairOrder:          replaceOrder,
+							Limit:              replaceCount,
+							PerLine:            replacePerLine,
+							DryRun:             dryRun,
+							BackupSuffix:       replaceBackup,
+							Force:              force,
+							Fsync:              fsync,
+							PreserveTimestamps: preserveTimestamps,
+							Roots:              replaceRoots,
+							AllowOutside:       allowOutside,
+							Interactive:        replaceInteractive,
+							Input:              os.Stdin,
+							Prompt:             os.Stdout,
+							ChangesJSON:        replaceDryRunJSON,
+							Output:             out,
+							Scope:              scope,
+							PreviewLimit:       replacePreviewLimit,
+							Conflict:           conflict,
+							Verify:             verifyReplace,
+							Stats:              stats,
+							UndoDir:            undoDir,
+						})
+						if err != nil {
+							return err
+						}
+						opts.Replacer = replacer
+						stats.replacing(dryRun || replaceDryRunJSON)
+					}
+					if stdinReplace {
+						err = opts.Replacer.ReplaceStream(c.Context, os.Stdin, out)
+						if ferr := flush(); err == nil {
+							err = ferr
+						}
+						return err
+					}
+
+					if utf8.RuneCountInString(pathSeparator) > 1 {
+						return fmt.Errorf("--path-separator must be a single character")
+					}
+					opts.PathSeparator = pathSeparator
+					opts.AbsolutePath = absolutePath
+					if scopePatterns.Value() != nil && !contextScope {
+						return fmt.Errorf("--scope-pattern requires --context-scope")
+					}
+					if contextScope {
+						if opts.Scopes, err = ParseScopePatterns(scopePatterns.Value()); err != nil {
+							return err
+						}
+					}
+					if err := setColor(colorWhen, outputPath == "" && isTerminal(os.Stdout)); err != nil {
+						return err
+					}
+					if opts.Colors, err = ParseColors(colors.Value()); err != nil {
+						return err
+					}
+					if outputPath != "" {
+						// Results in a file are not for a terminal.
+						opts.Colors = &ColorScheme{}
+					}
+					ctx, cancel := context.WithCancel(c.Context)
+					defer cancel()
+					reporter, err := NewReporter(format, out, opts)
+					if err != nil {
+						return err
+					}
+					if events, ok := reporter.(ErrorEvents); ok {
+						opts.Errors.events = events
+					}
+					var quietReporter *QuietReporter
+					if quiet {
+						quietReporter = NewQuietReporter(cancel)
+						reporter = quietReporter
+					}
+					if groupByCount {
+						reporter = NewGroupByCountReporter(reporter, top)
+					} else if top > 0 {
+						reporter = NewTopReporter(reporter, top)
+					}
+					if unique || uniquePerFile {
+						reporter = NewUniqueReporter(reporter, opts, uniquePerFile)
+					}
+					if bufferPerFile {
+						reporter = NewBlockReporter(reporter)
+					}
+					if orderedByFile {
+						opts.Ordered = NewOrderedReporter(reporter)
+						reporter = opts.Ordered
+					}
+					opts.Reporter = reporter
 
-func (f *CurrentFolderWalker) List(dir string, query string, regex bool, r *regexp.Regexp, caseInsensitive, wholeWord bool) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	for _, file := range files {
-		filePath := filepath.Join(dir, file.Name())
-		fmt.Println(file.Name())
-		readFile(filePath, query, regex, r, caseInsensitive, wholeWord)
+					if maxBytes > 0 {
+						opts.ByteLimit = newByteLimit(maxBytes, cancel)
+					}
+					if follow {
+						err = followFiles(ctx, roots, opts, flush)
+					} else if stdinSearch {
+						err = searchStdin(ctx, opts)
+					} else {
+						newVisited := func() *visitedFiles {
+							if allowDuplicateFiles {
+								return nil
+							}
+							visited := newVisitedFiles()
+							if outputPath != "" {
+								// Marked as seen so the search does not
+								// read back what it writes.
+								visited.first(outputPath)
+							}
+							return visited
+						}
+						// Dry runs and interactive replaces write nothing
+						// unasked; any other replace over the threshold
+						// is counted first and confirmed.
+						if opts.Replacer != nil && !dryRun && !replaceInteractive && !yes && confirmThreshold > 0 {
+							files, replacements, perr := previewReplace(ctx, roots, opts, newVisited())
+							if perr != nil {
+								return perr
+							}
+							if err := confirmReplace(files, replacements, confirmThreshold); err != nil {
+								return err
+							}
+						}
+						opts.Visited = newVisited()
+						err = parallelListAndRead(ctx, roots, opts)
+					}
+					if opts.ByteLimit.hit() && c.Context.Err() == nil {
+						// Spending the budget is a normal end of the run.
+						err = nil
+						opts.Stats.limitReached()
+					}
+					if quiet && c.Context.Err() == nil {
+						// The scan was cut short on purpose; only a match
+						// decides the exit status, not the skipped files.
+						if errors.Is(err, context.Canceled) {
+							err = nil
+						}
+						if quietReporter.Found() {
+							return err
+						}
+						if err == nil && opts.Errors.count() == 0 {
+							return &exitError{code: exitNoMatch, err: errors.New("no match"), silent: true}
+						}
+					}
+					// Close and flush run on every exit from the scan,
+					// including cancellation, so no buffered result is lost.
+					if cerr := reporter.Close(); err == nil {
+						err = cerr
+					}
+					if opts.Replacer != nil {
+						if rerr := opts.Replacer.Close(); err == nil {
+							err = rerr
+						}
+					}
+					if ferr := flush(); err == nil {
+						err = ferr
+					}
+					if opts.ByteLimit.hit() && err == nil {
+						fmt.Fprintf(os.Stderr, "Stopped after reading %d bytes (--max-bytes)\n", maxBytes)
+					}
+					if n := opts.Errors.count(); n > 0 && err == nil {
+						err = &exitError{code: exitTrouble, err: fmt.Errorf("%d file(s) could not be searched", n), silent: true}
+					}
+					return err
+				},
+			},
+			newStatsCommand(),
+			newFindCommand(),
+			newReplaceUndoCommand(),
+		},
 	}
 }
 
-type RecursiveFolderWalker struct{}
+// exitNoMatch is the exit status of --quiet when nothing matched.
+const exitNoMatch = 1
 
-func (f *RecursiveFolderWalker) List(dir string, query string, regex bool, r *regexp.Regexp, caseInsensitive, wholeWord bool) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	for _, file := range files {
-		filePath := filepath.Join(dir, file.Name())
-		fmt.Println(file.Name())
-		readFile(filePath, query, regex, r, caseInsensitive, wholeWord)
-	}
-}
+// exitTrouble is the exit status when a search target does not exist or
+// cannot be opened, or a file could not be searched, as grep uses for
+// errors.
+const exitTrouble = 2
 
-type FileWalkerStrategy struct {
-	fileWalkers map[FileWalkerType]FileWalker
+// exitError is an error that ends the program with the given status instead
+// of the usual 1. A silent error was already reported and is not printed
+// again.
+type exitError struct {
+	code   int
+	err    error
+	silent bool
 }
 
-func NewFileWalkerStrategy() *FileWalkerStrategy {
-	return &FileWalkerStrategy{
-		fileWalkers: make(map[FileWalkerType]FileWalker),
-	}
-}
+func (e *exitError) Error() string { return e.err.Error() }
 
-func (f *FileWalkerStrategy) Add(workerType FileWalkerType, fileWalker FileWalker) {
-	f.fileWalkers[workerType] = fileWalker
-}
+func (e *exitError) Unwrap() error { return e.err }
 
-func (f *FileWalkerStrategy) List(dir string, query string, regex bool, r *regexp.Regexp, walkerType FileWalkerType, caseInsensitive, wholeWord bool) {
-	if _, ok := f.fileWalkers[walkerType]; !ok {
-		fmt.Errorf("unknown walkertype")
+// resolveWithFilename decides whether matches are prefixed with their file
+// name. Like grep, a single file target omits it while a directory or several
+// targets include it, unless -H or -h says otherwise; -h wins when both are
+// given.
+func resolveWithFilename(roots []string, withFilename, noFilename bool) bool {
+	if noFilename {
+		return false
+	}
+	if withFilename || len(roots) != 1 {
+		return true
 	}
-	f.fileWalkers[walkerType].List(dir, query, regex, r, caseInsensitive, wholeWord)
+	info, err := os.Stat(roots[0])
+	return err != nil || info.IsDir()
 }
 
-func main() {
-	var dirPath, query string
-	var isRegex, isRecursive, caseInsensitive, wholeWord bool
-
-	app := &cli.App{
-		Commands: []*cli.Command{
-			{
-				Name:  "search",
-				Usage: "Search files in a directory",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:        "dir",
-						Aliases:     []string{"d"},
-						Usage:       "Directory to search in",
-						Destination: &dirPath,
-						Required:    true,
-					},
-					&cli.StringFlag{
-						Name:        "query",
-						Aliases:     []string{"q"},
-						Usage:       "Search query",
-						Destination: &query,
-						Required:    true,
-					},
-					&cli.BoolFlag{
-						Name:        "regex",
-						Aliases:     []string{"r"},
-						Usage:       "Use regular expression for search",
-						Destination: &isRegex,
-					},
-					&cli.BoolFlag{
-						Name:        "recursive",
-						Aliases:     []string{"R"},
-						Usage:       "Search recursively in subdirectories",
-						Destination: &isRecursive,
-					},
-					&cli.BoolFlag{
-						Name:        "case-insensitive",
-						Aliases:     []string{"i"},
-						Usage:       "Perform case-insensitive search",
-						Destination: &caseInsensitive,
-					},
-					&cli.BoolFlag{
-						Name:        "whole-word",
-						Aliases:     []string{"w"},
-						Usage:       "Match whole words only",
-						Destination: &wholeWord,
-					},
-				},
-				Action: func(c *cli.Context) error {
-					var regex *regexp.Regexp
-					if isRegex {
-						regex, _ = regexp.Compile(query)
-					}
-
-					walkerType := Current
-					if isRecursive {
-						walkerType = Recursive
-					}
-
-					err := parallelListAndRead(dirPath, query, isRegex, regex, walkerType, caseInsensitive, wholeWord)
-					return err
-				},
-			},
-		},
+// loadIgnoreFiles builds the matcher for --ignore-file, the project-local
+// .findmeignore of each search root and, with global, the user's global git
+// excludes file. The global patterns come first, so a project file can
+// re-include what they ignore, as in git.
+func loadIgnoreFiles(roots []string, files []string, global bool) (*IgnoreMatcher, error) {
+	ignore := NewIgnoreMatcher()
+	if global {
+		if name := globalGitIgnoreFile(); name != "" {
+			// A missing file ignores nothing, as for git.
+			if err := ignore.AddFile(name); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("reading the global gitignore: %w", err)
+			}
+		}
 	}
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+	for _, root := range roots {
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			project := filepath.Join(root, ProjectIgnoreFile)
+			if err := ignore.AddFileAt(project, root); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range files {
+		if err := ignore.AddFile(name); err != nil {
+			return nil, fmt.Errorf("reading ignore file: %w", err)
+		}
 	}
+	return ignore, nil
 }
 
-func parallelListAndRead(dirPath, query string, regex bool, r *regexp.Regexp, walkerType FileWalkerType, caseInsensitive, wholeWord bool) error {
-	ctx, cancel := context.WithCancel(context.Background())
+func parallelListAndRead(parent context.Context, roots []string, opts *SearchOptions) error {
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
 
-	// Channel to send file paths for reading
-	fileChan := make(chan string)
+	// Channel to send file paths for reading. It is buffered so its depth
+	// tells the auto mode whether readers keep up with the walker.
+	readers := initialReaders(opts)
+	fileChan := make(chan string, readers*fileQueueFactor)
 
-	// Start goroutines to list files concurrently
+	// A single walker feeds the channel; running several would enqueue
+	// every file once per walker.
 	var wgList sync.WaitGroup
-	numWorkers := runtime.NumGoroutine()
-	for i := 0; i < numWorkers; i++ {
-		wgList.Add(1)
-		go listFiles(ctx, dirPath, query, regex, r, walkerType, fileChan, &wgList)
-	}
+	wgList.Add(1)
+	go func() {
+		defer wgList.Done()
+		if opts.FileList != nil {
+			listFileNames(ctx, opts.FileList, opts.FileListDelimiter, opts, fileChan)
+			return
+		}
+		for _, root := range roots {
+			listRootSafely(ctx, root, opts, fileChan)
+		}
+	}()
 
 	// Start goroutines to read files concurrently
 	var wgRead sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wgRead.Add(1)
-		go readFileWorker(ctx, fileChan, query, regex, r, &wgRead, caseInsensitive, wholeWord)
+	startReaders := func(n int) {
+		for i := 0; i < n; i++ {
+			wgRead.Add(1)
+			go readFileWorker(ctx, fileChan, opts, &wgRead)
+		}
+	}
+	startReaders(readers)
+
+	done := make(chan struct{})
+	var wgScale sync.WaitGroup
+	if opts.Workers == 0 && !cpuBound(opts) {
+		wgScale.Add(1)
+		go func() {
+			defer wgScale.Done()
+			autoscaleReaders(done, fileChan, readers, startReaders)
+		}()
 	}
 
 	// Wait for file listing to complete
 	wgList.Wait()
 	close(fileChan)
+	close(done)
+	wgScale.Wait()
 
 	// Wait for file reading to complete
 	wgRead.Wait()
-	return nil
+	return parent.Err()
 }
 
-// listFiles lists files based on the walkerType and sends file paths to the channel.
-func listFiles(ctx context.Context, dirPath, query string, regex bool, r *regexp.Regexp, walkerType FileWalkerType, fileChan chan<- string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	strategy := NewFileWalkerStrategy()
-	strategy.Add(Current, &CurrentFolderWalker{})
-	strategy.Add(Recursive, &RecursiveFolderWalker{})
+// gitDir is the repository directory of git. Its objects are compressed and
+// its other files are rarely what a search is after, so the walk skips it
+// unless --search-git is given. A search root inside it is still searched.
+const gitDir = ".git"
+
+// listRootSafely is listFiles for the walker goroutine: a panic while walking
+// root is reported and the walk goes on with the next root.
+func listRootSafely(ctx context.Context, root string, opts *SearchOptions, fileChan chan<- string) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(opts, root, r)
+		}
+	}()
+	listFiles(ctx, root, opts, fileChan)
+}
+
+// listFiles lists the files below dirPath, down to opts.MaxDepth, and sends
+// their paths to the channel.
+func listFiles(ctx context.Context, dirPath string, opts *SearchOptions, fileChan chan<- string) {
+	if opts.GitTracked {
+		if err := listGitFiles(ctx, dirPath, opts, fileChan); err != nil && ctx.Err() == nil {
+			opts.Errors.report(FileError{Path: dirPath, Kind: ErrorList, Err: err}, "Error listing %s: %v", dirPath, err)
+		}
+		return
+	}
 	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
-			select {
-			case fileChan <- path:
-			case <-ctx.Done():
-				return ctx.Err()
+		if path != dirPath {
+			rel, err := filepath.Rel(dirPath, path)
+			if err != nil {
+				rel = path
+			}
+			if info.IsDir() {
+				if info.Name() == gitDir && !opts.SearchGit {
+					return filepath.SkipDir
+				}
+				if tooDeep(rel, opts.MaxDepth) || opts.Filter.SkipDir(rel) || opts.Ignore.Match(dirPath, rel, true) {
+					return filepath.SkipDir
+				}
+				return nil
 			}
+			if opts.Filter.SkipFile(rel) || opts.Ignore.Match(dirPath, rel, false) {
+				return nil
+			}
+		}
+		if !info.IsDir() && !queueFile(ctx, path, opts, fileChan) {
+			return ctx.Err()
 		}
 		return nil
 	})
-	if err != nil {
-		fmt.Println(err.Error())
+	if err != nil && ctx.Err() == nil {
+		opts.Errors.report(FileError{Path: dirPath, Kind: ErrorList, Err: err}, "%v", err)
 	}
 }
 
-func readFileWorker(ctx context.Context, fileChan <-chan string, query string, regex bool, r *regexp.Regexp, wg *sync.WaitGroup, caseInsensitive, wholeWord bool) {
+// queueFile sends path to the readers unless opts.Visited has seen its file
+// already. It returns false once ctx is done.
+func queueFile(ctx context.Context, path string, opts *SearchOptions, fileChan chan<- string) bool {
+	if !opts.Visited.first(path) {
+		return true
+	}
+	if opts.Ordered != nil {
+		opts.Ordered.Enqueue(path)
+	}
+	opts.Stats.walked()
+	select {
+	case fileChan <- path:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func readFileWorker(ctx context.Context, fileChan <-chan string, opts *SearchOptions, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
@@ -215,7 +1432,19 @@ func readFileWorker(ctx context.Context, fileChan <-chan string, query string, r
 			if !ok {
 				return // Channel closed
 			}
-			readFile(fileName, query, regex, r, caseInsensitive, wholeWord)
+			fileCtx, cancel := ctx, context.CancelFunc(func() {})
+			if opts.FileTimeout > 0 {
+				fileCtx, cancel = context.WithTimeout(ctx, opts.FileTimeout)
+			}
+			readFileSafely(fileCtx, fileName, opts)
+			if ctx.Err() == nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+				fmt.Fprintf(os.Stderr, "Warning: skipped the rest of %s after %s (--timeout-per-file)\n", fileName, opts.FileTimeout)
+				opts.Stats.skipped()
+			}
+			cancel()
+			if opts.Ordered != nil {
+				opts.Ordered.Done(fileName)
+			}
 
 		case <-ctx.Done():
 			return // Context canceled
@@ -223,138 +1452,430 @@ func readFileWorker(ctx context.Context, fileChan <-chan string, query string, r
 	}
 }
 
-func readFile(fileName string, query string, regex bool, r *regexp.Regexp, caseInsensitive, wholeWord bool) {
+// readFileSafely is readFile for the reader pool: a panic while searching a
+// file is reported and the reader moves on to the next file.
+func readFileSafely(ctx context.Context, fileName string, opts *SearchOptions) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(opts, fileName, r)
+		}
+	}()
+	readFile(ctx, fileName, opts)
+}
+
+// reportPanic reports a panic recovered while searching fileName as an
+// error of that file, so it counts towards the exit status. Results found so
+// far are kept and the scan goes on with the other files.
+func reportPanic(opts *SearchOptions, fileName string, r interface{}) {
+	opts.Errors.report(FileError{Path: fileName, Kind: ErrorInternal, Err: fmt.Errorf("%v", r)}, "Error: internal error while searching %s: %v", fileName, r)
+}
+
+func readFile(ctx context.Context, fileName string, opts *SearchOptions) {
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		fmt.Printf("Error: File %s does not exist.\n", fileName)
+		opts.Errors.report(FileError{Path: fileName, Kind: ErrorNotFound, Err: err}, "Error: File %s does not exist.", fileName)
+		opts.Stats.skipped()
+		return
+	}
+
+	if opts.Replacer != nil {
+		err := opts.Replacer.ReplaceFile(ctx, fileName)
+		switch {
+		case err == nil:
+			opts.Stats.searched(0, 0)
+		case ctx.Err() == nil:
+			opts.Errors.report(FileError{Path: fileName, Kind: ErrorReplace, Err: err}, "Error replacing in file %s: %v", fileName, err)
+			opts.Stats.skipped()
+		}
+		return
+	}
+
+	if opts.SearchZip && isZipFile(fileName) {
+		searchZip(ctx, fileName, opts)
 		return
 	}
+
 	file, err := os.Open(fileName)
 	if err != nil {
-		fmt.Printf("Error opening file %s: %v\n", fileName, err)
+		opts.Errors.report(FileError{Path: fileName, Kind: ErrorRead, Err: err}, "Error opening file %s: %v", fileName, err)
+		opts.Stats.skipped()
 		return
 	}
 	defer file.Close()
 
 	// Use bufio.Reader for efficient file reading
 	reader := bufio.NewReader(file)
-	Process(reader, query, regex, r, fileName, caseInsensitive, wholeWord)
+	Process(ctx, reader, fileName, opts)
+}
+
+// chunk is a piece of a file that ends on a line boundary. startLine is the
+// number of its first line and offset the byte position of its first byte,
+// which lets concurrent workers report accurate positions without seeing the
+// rest of the file.
+type chunk struct {
+	data      []byte
+	startLine int
+	offset    int64
 }
 
-func Process(reader *bufio.Reader, query string, regex bool, re *regexp.Regexp, fileName string, caseInsensitive, wholeWord bool) error {
+// chunkSize is how much of a file Process reads for a chunk, and chunkSlack
+// the room its buffers keep for completing the chunk's last line.
+const (
+	chunkSize  = 250 * 1024
+	chunkSlack = 4 * 1024
+)
+
+// Process scans reader for matches, fanning chunks out to concurrent workers.
+// It stops reading as soon as ctx is cancelled and returns ctx.Err().
+func Process(ctx context.Context, reader *bufio.Reader, fileName string, opts *SearchOptions) error {
+	if opts.ByteLimit.hit() {
+		return nil
+	}
+	// The file that spends the --max-bytes budget is searched as far as
+	// the budget goes before the scan is cancelled.
+	defer opts.ByteLimit.stop()
+	if opts.WholeFile != nil {
+		return processWholeFile(ctx, reader, fileName, opts)
+	}
+	if opts.Multiline {
+		return processMultiline(ctx, reader, fileName, opts)
+	}
+
+	// Chunk buffers are recycled between reads. The pool holds *[]byte so
+	// that putting a buffer back does not allocate, and each has room past
+	// chunkSize for the rest of the chunk's last line.
 	linesPool := sync.Pool{New: func() interface{} {
-		lines := make([]byte, 250*1024)
-		return lines
-	}}
-	stringPool := sync.Pool{New: func() interface{} {
-		lines := ""
-		return lines
+		lines := make([]byte, chunkSize, chunkSize+chunkSlack)
+		return &lines
 	}}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	chunkChan := make(chan []byte)
-	numWorkers := runtime.NumGoroutine()
-	queryHash := calculateHash(query)
+	counter := &fileCount{stop: cancel}
 
+	chunkChan := make(chan chunk)
+	numWorkers := runtime.NumCPU()
+	if cap(opts.CPUSlots) > 0 {
+		numWorkers = min(numWorkers, cap(opts.CPUSlots))
+	}
+	scope := newScopeTracker(opts.Scopes.forFile(fileName))
+	if (opts.MaxCount > 0 && !opts.countOnly()) || opts.SortFiles || scope != nil {
+		// A single worker sees the chunks in file order, so the lines
+		// printed are the first --max-count ones, and in line order, and
+		// the scope of a line is the last one opened before it.
+		numWorkers = 1
+	}
 	var wg sync.WaitGroup
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go processChunkWorker(ctx, chunkChan, &linesPool, &stringPool, query, fileName, regex, re, queryHash, &wg, caseInsensitive, wholeWord)
+		go processChunkWorker(ctx, chunkChan, &linesPool, fileName, opts, counter, scope, &wg)
 	}
 
-	for {
-		buf := linesPool.Get().([]byte)
-		n, err := reader.Read(buf)
+	events, _ := opts.Reporter.(FileEvents)
+	if events != nil {
+		events.Begin(fileName)
+	}
+
+	lineNum := 1
+	var offset int64
+	if opts.Tail > 0 {
+		c, err := readTail(ctx, reader, opts.Tail, opts.Delimiter)
+		c.data, _ = opts.ByteLimit.take(c.data, opts.Delimiter)
+		offset = int64(len(c.data))
+		if err != nil && ctx.Err() == nil {
+			opts.Errors.report(FileError{Path: fileName, Kind: ErrorRead, Err: err}, "Error reading %s: %v", fileName, err)
+		}
+		if err == nil && len(c.data) > 0 {
+			select {
+			case chunkChan <- c:
+			case <-ctx.Done():
+			}
+		}
+	}
+read:
+	for opts.Tail == 0 && ctx.Err() == nil {
+		buf := *linesPool.Get().(*[]byte)
+		if cap(buf) < chunkSize {
+			// --max-bytes can hand back an emptied buffer.
+			buf = make([]byte, chunkSize, chunkSize+chunkSlack)
+		}
+		n, err := reader.Read(buf[:chunkSize])
+		if n > 0 && n < chunkSize && err == nil {
+			// Completing the previous chunk's last line leaves the rest of
+			// the reader's buffer behind, and that is all a first read
+			// returns; fill the chunk from the file too.
+			var m int
+			m, err = reader.Read(buf[n:chunkSize])
+			n += m
+		}
 		buf = buf[:n]
 		if n == 0 {
-			if err != nil {
-				if err != io.EOF {
-					fmt.Println(err)
-				}
-				break
+			// Leave through the bottom of the loop even on an empty read,
+			// so the workers are drained and End is always reported.
+			linesPool.Put(&buf)
+			if err != nil && err != io.EOF {
+				opts.Errors.report(FileError{Path: fileName, Kind: ErrorRead, Err: err}, "Error reading %s: %v", fileName, err)
+			}
+			break
+		}
+
+		// Complete the last line of the chunk, including an unterminated
+		// final line at EOF.
+		nextUntilNewline, _ := reader.ReadBytes(opts.Delimiter)
+		buf = append(buf, nextUntilNewline...)
+
+		// --lines skips what comes before its first line.
+		if opts.FromLine > lineNum {
+			var lines, n int
+			buf, lines, n = skipLines(buf, lineNum, opts.FromLine, opts.Delimiter)
+			lineNum += lines
+			offset += int64(n)
+			if len(buf) == 0 {
+				linesPool.Put(&buf)
+				continue
 			}
-			return err
 		}
 
-		nextUntilNewline, err := reader.ReadBytes('\n')
-		if err != io.EOF {
-			buf = append(buf, nextUntilNewline...)
+		// --head, and the end of --lines, stop reading once the chunk
+		// reaches the last wanted line.
+		last := false
+		if opts.Head > 0 {
+			buf = cutLines(buf, opts.Head-lineNum+1, opts.Delimiter)
+			last = lineNum+bytes.Count(buf, []byte{opts.Delimiter}) > opts.Head
+		}
+		if opts.ByteLimit != nil {
+			var spent bool
+			buf, spent = opts.ByteLimit.take(buf, opts.Delimiter)
+			last = last || spent
+			if len(buf) == 0 {
+				linesPool.Put(&buf)
+				break
+			}
 		}
 
+		c := chunk{data: buf, startLine: lineNum, offset: offset}
+		lineNum += bytes.Count(buf, []byte{opts.Delimiter})
+		offset += int64(len(buf))
+
 		select {
-		case chunkChan <- buf:
+		case chunkChan <- c:
 		case <-ctx.Done():
-			return ctx.Err()
+			break read
+		}
+		if last {
+			break
 		}
 	}
 
 	close(chunkChan)
 	wg.Wait()
+
+	if events != nil {
+		events.End(fileName, counter.result(opts.MaxCount))
+	}
+
+	// The file context is also cancelled by --files-with-matches once a
+	// match is found; only a cancelled parent aborts the scan.
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	opts.Stats.searched(offset, counter.result(opts.MaxCount))
+	if opts.countOnly() {
+		opts.Reporter.Count(fileName, counter.result(opts.MaxCount))
+	}
 	return nil
 }
 
-func processChunkWorker(ctx context.Context, chunkChan <-chan []byte, linesPool *sync.Pool, stringPool *sync.Pool, query string, fileName string, regex bool, r *regexp.Regexp, queryHash uint32, wg *sync.WaitGroup, caseInsensitive, wholeWord bool) {
+// cancelCheckLines is how often, in lines, chunk workers check whether the
+// scan was cancelled, so a timed out or interrupted file stops mid-chunk.
+const cancelCheckLines = 256
+
+// fileCount collects the number of selected lines of one file from its chunk
+// workers. stop ends the scan of the file early once the answer is known.
+type fileCount struct {
+	n    atomic.Int64
+	stop context.CancelFunc
+}
+
+// take counts one selected line and reports whether it is still within max
+// (zero means no limit). Reaching max stops the scan of the file.
+func (c *fileCount) take(max int) bool {
+	n := c.n.Add(1)
+	if max > 0 && n >= int64(max) {
+		c.stop()
+	}
+	return max == 0 || n <= int64(max)
+}
+
+// result returns the count to report. Concurrent workers can overshoot max
+// before the scan stops, so the count is capped.
+func (c *fileCount) result(max int) int {
+	n := int(c.n.Load())
+	if max > 0 && n > max {
+		return max
+	}
+	return n
+}
+
+func processChunkWorker(ctx context.Context, chunkChan <-chan chunk, linesPool *sync.Pool, fileName string, opts *SearchOptions, counter *fileCount, scope *scopeTracker, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(opts, fileName, r)
+			// Stop the file so the reader does not wait for a worker
+			// that is gone.
+			counter.stop()
+		}
+	}()
 
-	for {
-		select {
-		case chunk, ok := <-chunkChan:
-			if !ok {
-				return
-			}
+	var matches func([]byte) bool
+	if opts.countOnly() {
+		matches = func(line []byte) bool {
+			return opts.LineLengths.fits(line) && matchesLine(opts.Matcher, line) != opts.Invert
+		}
+	}
+	literal := chunkLiteral(opts)
+	if scope != nil {
+		// Scopes open on lines without a hit, so every line is read.
+		literal = nil
+	}
 
-			scanner := bufio.NewScanner(bytes.NewReader(chunk))
-			for scanner.Scan() {
-				line := scanner.Text()
-				line = strings.TrimRight(line, "\r\n")
-				if len(line) == 0 {
-					continue
-				}
+	// selected counts the lines of the current chunk without --max-count.
+	// They are added to counter once per chunk, so workers do not contend
+	// on it for every line; with --max-count every line is taken from
+	// counter at once, since that is what stops the file.
+	var selected int64
+	flush := func() {
+		if selected > 0 {
+			counter.n.Add(selected)
+			selected = 0
+		}
+	}
 
-				var lineStr string
-				if v := stringPool.Get(); v != nil {
-					lineStr = v.(string)
-				} else {
-					lineStr = ""
-				}
-				lineStr = line
+	// report handles a line of the chunk and returns false once the file
+	// has reported enough.
+	report := func(lineNum int, lineOffset int64, record []byte) bool {
+		enclosing := scope.next(lineNum, record)
+		if !opts.LineLengths.fits(record) {
+			return true
+		}
+		spans := opts.Matcher.Match(record)
+		found := spans != nil
+		if found == opts.Invert {
+			return true
+		}
+		if opts.MaxCount == 0 {
+			selected++
+		} else if !counter.take(opts.MaxCount) {
+			return false
+		}
+		line := string(record)
+		if !opts.OnlyMatching {
+			m := Match{Path: fileName, Line: lineNum, Offset: lineOffset, Text: line, Scope: enclosing}
+			// An inverted match has no span to highlight.
+			if found {
+				m.Start, m.End, m.Spans = spans[0].Start, spans[0].End, spans
+			}
+			opts.Reporter.Match(m)
+			return true
+		}
+		// --only-matching prints every non-empty match of the line on
+		// its own, up to --max-matches-per-line.
+		reported := 0
+		for _, sp := range spans {
+			if opts.MaxMatchesPerLine > 0 && reported == opts.MaxMatchesPerLine {
+				break
+			}
+			if sp.Start < sp.End {
+				opts.Reporter.Match(Match{Path: fileName, Line: lineNum, Offset: lineOffset, Text: line, Start: sp.Start, End: sp.End, Spans: []Span{sp}, Scope: enclosing})
+				reported++
+			}
+		}
+		return true
+	}
 
-				if regex {
-					if r.MatchString(lineStr) {
-						fmt.Println(color.Error.Sprintf("%s %s", query, fileName))
-					}
-				} else {
-					if caseInsensitive {
-						lineStr = strings.ToLower(lineStr)
-						query = strings.ToLower(query)
-					}
+	// processChunk matches the lines of c in the CPU slot its caller took.
+	// The buffer and the slot are given back on every way out, a panic
+	// included, or a panicking file would hold its slot for the rest of the
+	// run and, with --threads-cpu, stall every other file.
+	processChunk := func(c chunk) {
+		defer opts.CPUSlots.release()
+		defer linesPool.Put(&c.data)
 
-					if wholeWord {
-						query = fmt.Sprintf("\\b%s\\b", query)
-						r, _ = regexp.Compile(query)
-						if r.MatchString(lineStr) {
-							fmt.Println(color.Error.Sprintf("%s %s", query, fileName))
-						}
-					} else {
-						for i := 0; i <= len(lineStr)-len(query); i++ {
-							windowHash := calculateHash(lineStr[i : i+len(query)])
-							if windowHash == queryHash && lineStr[i:i+len(query)] == query {
-								fmt.Println(color.Error.Sprintf("%s %s", query, fileName))
-								break
-							}
-						}
+		if matches != nil {
+			var n int
+			if literal != nil {
+				scanHitLines(ctx, c, literal, opts.Delimiter, func(_ int, _ int64, record []byte) bool {
+					if opts.LineLengths.fits(record) {
+						n++
 					}
+					return true
+				})
+			} else {
+				n = countChunk(ctx, c.data, opts.Delimiter, matches)
+			}
+			if n > 0 {
+				total := counter.n.Add(int64(n))
+				if opts.CountMode.stopsAtFirstMatch() || (opts.MaxCount > 0 && total >= int64(opts.MaxCount)) {
+					counter.stop()
 				}
-
-				stringPool.Put(&lineStr)
 			}
+			return
+		}
+
+		if literal != nil {
+			scanHitLines(ctx, c, literal, opts.Delimiter, func(lineNum int, lineOffset int64, record []byte) bool {
+				return report(lineNum, lineOffset, record)
+			})
+			flush()
+			return
+		}
 
-			if err := scanner.Err(); err != nil {
-				fmt.Printf("Error scanning chunk: %v\n", err)
+		// Lines are split on \n only; ScanLines drops the \r of a CRLF
+		// ending, so columns are the same for LF, CRLF and mixed files.
+		// With --line-delimiter the records end in that byte instead.
+		scanner := bufio.NewScanner(bytes.NewReader(c.data))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(c.data)+1)
+		var pos, lineStart int64
+		split := scanRecords(opts.Delimiter)
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := split(data, atEOF)
+			if token != nil {
+				lineStart = pos
+			}
+			pos += int64(advance)
+			return advance, token, err
+		})
+		lineNum := c.startLine - 1
+		for scanner.Scan() {
+			lineNum++
+			if (lineNum-c.startLine+1)%cancelCheckLines == 0 && ctx.Err() != nil {
+				break
+			}
+			record := trimRecord(scanner.Bytes(), opts.Delimiter)
+			if !report(lineNum, c.offset+lineStart, record) {
+				break
 			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error scanning chunk: %v\n", err)
+		}
+		flush()
+	}
 
-			linesPool.Put(&chunk)
+	for {
+		select {
+		case c, ok := <-chunkChan:
+			if !ok {
+				return
+			}
+			if !opts.CPUSlots.acquire(ctx) {
+				linesPool.Put(&c.data)
+				return
+			}
+			processChunk(c)
 
 		case <-ctx.Done():
 			return
@@ -362,6 +1883,24 @@ func processChunkWorker(ctx context.Context, chunkChan <-chan []byte, linesPool
 	}
 }
 
-func calculateHash(s string) uint32 {
-	return murmur3.Sum32([]byte(s))
+// countChunk returns the number of lines in data accepted by matches. It is
+// the fast path of the count-only modes: the lines are cut out of data in
+// place, so it allocates nothing.
+func countChunk(ctx context.Context, data []byte, delim byte, matches func([]byte) bool) int {
+	n := 0
+	for lines := 1; len(data) > 0; lines++ {
+		if lines%cancelCheckLines == 0 && ctx.Err() != nil {
+			break
+		}
+		record := data
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			record, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if matches(trimRecord(record, delim)) {
+			n++
+		}
+	}
+	return n
 }
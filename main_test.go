@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+// captureReporter records what a search reports so a test can compare it
+// with what it expects.
+type captureReporter struct {
+	mu      sync.Mutex
+	matches []Match
+	counts  map[string]int
+	ends    map[string]int
+	errors  []FileError
+	closed  bool
+}
+
+func newCaptureReporter() *captureReporter {
+	return &captureReporter{counts: make(map[string]int), ends: make(map[string]int)}
+}
+
+func (r *captureReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.matches = append(r.matches, m)
+}
+
+func (r *captureReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[path] += n
+}
+
+func (r *captureReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *captureReporter) Begin(path string) {}
+
+func (r *captureReporter) End(path string, matches int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ends[path] += matches
+}
+
+func (r *captureReporter) FileError(e FileError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+func (r *captureReporter) FileSkipped(e FileError) {}
+
+// lines returns the matches as path:line:text in the order they were
+// reported, with the paths relative to root and slash separated.
+func (r *captureReporter) lines(root string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, 0, len(r.matches))
+	for _, m := range r.matches {
+		path := m.Path
+		if rel, err := filepath.Rel(root, m.Path); err == nil {
+			path = filepath.ToSlash(rel)
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d:%s", path, m.Line, m.Text))
+	}
+	return lines
+}
+
+// writeTree creates the files, named by slash separated paths, below a new
+// temporary directory and returns it.
+func writeTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// testOptions returns the options of a plain search for query, as the
+// search command sets them up, after edit has adjusted the flags. The
+// matches go to the returned reporter.
+func testOptions(t testing.TB, query string, edit func(*SearchOptions)) (*SearchOptions, *captureReporter) {
+	t.Helper()
+	rep := newCaptureReporter()
+	opts := &SearchOptions{
+		Query:      query,
+		Patterns:   []string{query},
+		MaxDepth:   unlimitedDepth,
+		Errors:     &fileErrors{quiet: true},
+		Delimiter:  '\n',
+		ColumnUnit: ColumnByte,
+		Workers:    1,
+		Reporter:   rep,
+	}
+	opts.Errors.events = rep
+	if edit != nil {
+		edit(opts)
+	}
+	if opts.Regex && opts.Re == nil {
+		pattern := opts.Query
+		if opts.CaseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		opts.Re = regexp.MustCompile(pattern)
+	}
+	var err error
+	if opts.WordChars == nil {
+		if opts.WordChars, err = NewWordChars("", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opts.Matcher == nil {
+		if opts.Matcher, err = NewMatcher(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return opts, rep
+}
+
+// search runs opts over roots as the search command does and returns the
+// matches as lines of captureReporter.lines, relative to base. With a nil
+// rep, the matches went elsewhere and none are returned.
+func search(t testing.TB, base string, roots []string, opts *SearchOptions, rep *captureReporter) []string {
+	t.Helper()
+	if err := parallelListAndRead(context.Background(), roots, opts); err != nil {
+		t.Fatal(err)
+	}
+	if rep == nil {
+		return nil
+	}
+	return rep.lines(base)
+}
+
+// sorted returns a sorted copy of lines, for results whose order across
+// files depends on the readers.
+func sorted(lines []string) []string {
+	lines = append([]string(nil), lines...)
+	sort.Strings(lines)
+	return lines
+}
+
+func equalLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+func TestListFilesDepth(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":          "",
+		"sub/b.txt":      "",
+		"sub/deep/c.txt": "",
+		".git/config":    "",
+	})
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"a.txt"}},
+		{1, []string{"a.txt", "sub/b.txt"}},
+		{unlimitedDepth, []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"}},
+	}
+	for _, tt := range tests {
+		opts, _ := testOptions(t, "x", func(o *SearchOptions) { o.MaxDepth = tt.depth })
+		files := make(chan string, 16)
+		listFiles(context.Background(), root, opts, files)
+		close(files)
+		var got []string
+		for path := range files {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		// The walk is lexical, so the files come out sorted.
+		t.Run(fmt.Sprint(tt.depth), func(t *testing.T) { equalLines(t, got, tt.want) })
+	}
+}
+
+func TestSearchRecursion(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":          "needle\nhay\n",
+		"sub/b.txt":      "hay\nneedle\n",
+		"sub/deep/c.txt": "needle\n",
+	})
+	tests := []struct {
+		name  string
+		depth int
+		want  []string
+	}{
+		{"flat", 0, []string{"a.txt:1:needle"}},
+		{"recursive", unlimitedDepth, []string{"a.txt:1:needle", "sub/b.txt:2:needle", "sub/deep/c.txt:1:needle"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, rep := testOptions(t, "needle", func(o *SearchOptions) { o.MaxDepth = tt.depth })
+			equalLines(t, sorted(search(t, root, []string{root}, opts, rep)), tt.want)
+		})
+	}
+}
+
+func TestRecursiveFlag(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":          "needle\n",
+		"sub/b.txt":      "needle\n",
+		"sub/deep/c.txt": "needle\n",
+	})
+	chdir(t, root)
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"a.txt"}},
+		{[]string{"--recursive=false"}, []string{"a.txt"}},
+		{[]string{"-R"}, []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"}},
+		{[]string{"--recursive=false", "--max-depth", "1"}, []string{"a.txt", "sub/b.txt"}},
+		{[]string{"-R", "--max-depth", "1"}, []string{"a.txt", "sub/b.txt"}},
+	}
+	for _, tt := range tests {
+		name := strings.Join(tt.args, " ")
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			stdout, _, err := runSearch(t, append([]string{"-d", ".", "-q", "needle", "-l"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Fields(stdout) {
+				got = append(got, filepath.ToSlash(filepath.Clean(line)))
+			}
+			equalLines(t, sorted(got), tt.want)
+		})
+	}
+}
+
+func TestSearchMatching(t *testing.T) {
+	const text = "Needle in a haystack\nneedles everywhere\na needle, then NEEDLE\nnothing here\n"
+	tests := []struct {
+		name  string
+		query string
+		edit  func(*SearchOptions)
+		want  []string
+	}{
+		{"literal", "needle", nil, []string{"2:needles everywhere", "3:a needle, then NEEDLE"}},
+		{"ignore case", "needle", func(o *SearchOptions) { o.CaseInsensitive = true },
+			[]string{"1:Needle in a haystack", "2:needles everywhere", "3:a needle, then NEEDLE"}},
+		{"whole word", "needle", func(o *SearchOptions) { o.WholeWord = true }, []string{"3:a needle, then NEEDLE"}},
+		{"whole word ignore case", "needle", func(o *SearchOptions) { o.WholeWord, o.CaseInsensitive = true, true },
+			[]string{"1:Needle in a haystack", "3:a needle, then NEEDLE"}},
+		{"regex", `^[a-z]+ (in|here)`, func(o *SearchOptions) { o.Regex = true }, []string{"4:nothing here"}},
+		{"regex ignore case", `needle\b`, func(o *SearchOptions) { o.Regex, o.CaseInsensitive = true, true },
+			[]string{"1:Needle in a haystack", "3:a needle, then NEEDLE"}},
+		{"invert", "needle", func(o *SearchOptions) { o.Invert = true }, []string{"1:Needle in a haystack", "4:nothing here"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, rep := testOptions(t, tt.query, tt.edit)
+			if err := Process(context.Background(), bufio.NewReader(strings.NewReader(text)), "f", opts); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range rep.lines("") {
+				got = append(got, strings.TrimPrefix(line, "f:"))
+			}
+			equalLines(t, got, tt.want)
+		})
+	}
+}
+
+func TestSearchOrderedByFile(t *testing.T) {
+	files := make(map[string]string)
+	var want []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%02d.txt", i)
+		files[name] = "x\nneedle\nx\nneedle\n"
+		want = append(want, name+":2:needle", name+":4:needle")
+	}
+	root := writeTree(t, files)
+
+	// Several readers finish the files in any order; the ordered reporter
+	// still prints them in the walk order, and each file in line order.
+	rep := newCaptureReporter()
+	opts, _ := testOptions(t, "needle", func(o *SearchOptions) {
+		o.Workers = 8
+		o.SortFiles = true
+		o.Ordered = NewOrderedReporter(rep)
+		o.Reporter = o.Ordered
+	})
+	equalLines(t, search(t, root, []string{root}, opts, rep), want)
+}
+
+// lineReader yields the line over and over, for limit reads, and counts the
+// reads. A read of the zero line returns nothing, once, before the data.
+type lineReader struct {
+	line  []byte
+	limit int64
+	reads atomic.Int64
+	empty bool
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if r.empty {
+		r.empty = false
+		return 0, nil
+	}
+	if r.reads.Add(1) > r.limit {
+		return 0, io.EOF
+	}
+	n := 0
+	for n+len(r.line) <= len(p) {
+		n += copy(p[n:], r.line)
+	}
+	return n, nil
+}
+
+// cancelReporter cancels the search at its first match.
+type cancelReporter struct {
+	*captureReporter
+	cancel context.CancelFunc
+}
+
+func (r cancelReporter) Match(m Match) {
+	r.captureReporter.Match(m)
+	r.cancel()
+}
+
+func TestProcessCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rep := cancelReporter{newCaptureReporter(), cancel}
+	opts, _ := testOptions(t, "needle", func(o *SearchOptions) { o.Reporter = rep })
+
+	const limit = 10000
+	r := &lineReader{line: []byte("a needle\n"), limit: limit}
+	err := Process(ctx, bufio.NewReader(r), "f", opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Process returned %v, want context.Canceled", err)
+	}
+	// The chunks already handed to the workers are searched, but no more
+	// of the file is read.
+	if reads := r.reads.Load(); reads >= limit/10 {
+		t.Errorf("Process read %d times after the cancel", reads)
+	}
+	if _, ok := rep.ends["f"]; !ok {
+		t.Error("no end event for the cancelled file")
+	}
+}
+
+func TestProcessEmptyRead(t *testing.T) {
+	opts, rep := testOptions(t, "needle", nil)
+	r := &lineReader{line: []byte("needle\n"), limit: 1, empty: true}
+	if err := Process(context.Background(), bufio.NewReader(r), "f", opts); err != nil {
+		t.Fatal(err)
+	}
+	// A read with neither data nor error ends the file like EOF, with its
+	// end event.
+	if n, ok := rep.ends["f"]; !ok || n != 0 {
+		t.Errorf("end event = %d, %v; want 0, true", n, ok)
+	}
+}
+
+// readCounter counts the reads of the reader it wraps.
+type readCounter struct {
+	io.Reader
+	reads int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(p)
+}
+
+func TestProcessChunkSize(t *testing.T) {
+	data := benchText(4 << 20)
+	opts, rep := testOptions(t, "needle", nil)
+	r := &readCounter{Reader: bytes.NewReader(data)}
+	if err := Process(context.Background(), bufio.NewReader(r), "f", opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Count(data, []byte("needle")); len(rep.matches) != want {
+		t.Errorf("%d matches, want %d", len(rep.matches), want)
+	}
+	// Every chunk takes a read for its body and one to complete its last
+	// line, so the file is read in chunks of the full size, not in what
+	// the bufio.Reader has left over.
+	if chunks := len(data)/chunkSize + 1; r.reads > 3*chunks {
+		t.Errorf("%d reads for %d chunks", r.reads, chunks)
+	}
+}
+
+// countTree writes a file of several chunks and a few small ones, and
+// returns its root with the number of lines of each file that contain
+// needle.
+func countTree(t testing.TB) (string, map[string]int) {
+	t.Helper()
+	files := make(map[string]string)
+	var big strings.Builder
+	for i := 0; i < 60000; i++ {
+		if i%3 == 0 || i%7 == 0 {
+			fmt.Fprintf(&big, "line %d has a needle\n", i)
+		} else {
+			fmt.Fprintf(&big, "line %d has hay\n", i)
+		}
+	}
+	files["big.txt"] = big.String()
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("small%d.txt", i)] = strings.Repeat("needle\nhay\n", i)
+	}
+	root := writeTree(t, files)
+
+	want := make(map[string]int)
+	for name, content := range files {
+		for _, line := range strings.SplitAfter(content, "\n") {
+			if strings.Contains(line, "needle") {
+				want[filepath.Join(root, name)]++
+			}
+		}
+	}
+	return root, want
+}
+
+func TestCountsAcrossWorkers(t *testing.T) {
+	root, want := countTree(t)
+	total := 0
+	for _, n := range want {
+		total += n
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		for _, threads := range []int{0, 1, 3} {
+			t.Run(fmt.Sprintf("workers=%d/threads-cpu=%d", workers, threads), func(t *testing.T) {
+				setup := func(o *SearchOptions) {
+					o.Workers = workers
+					if threads > 0 {
+						o.CPUSlots = make(cpuSlots, threads)
+					}
+				}
+
+				// --count
+				opts, rep := testOptions(t, "needle", func(o *SearchOptions) { setup(o); o.CountMode = CountLines })
+				search(t, root, []string{root}, opts, rep)
+				for path, n := range want {
+					if rep.counts[path] != n {
+						t.Errorf("--count of %s = %d, want %d", path, rep.counts[path], n)
+					}
+				}
+
+				// --count-total, through the text reporter
+				opts, _ = testOptions(t, "needle", func(o *SearchOptions) { setup(o); o.CountMode = CountTotal })
+				var out bytes.Buffer
+				text, err := NewReporter(FormatText, &out, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Reporter = text
+				search(t, root, []string{root}, opts, nil)
+				text.Close()
+				if got := out.String(); got != fmt.Sprintf("%d\n", total) {
+					t.Errorf("--count-total printed %q, want %d", got, total)
+				}
+
+				// printed lines and the end counts of --events
+				opts, rep = testOptions(t, "needle", setup)
+				search(t, root, []string{root}, opts, rep)
+				printed := make(map[string]int)
+				for _, m := range rep.matches {
+					printed[m.Path]++
+				}
+				for path, n := range want {
+					if printed[path] != n || rep.ends[path] != n {
+						t.Errorf("%s: %d lines printed and an end count of %d, want %d", path, printed[path], rep.ends[path], n)
+					}
+				}
+			})
+		}
+	}
+}
+
+// panicMatcher matches like its Matcher but panics on lines with boom.
+type panicMatcher struct{ Matcher }
+
+func (m panicMatcher) Match(line []byte) []Span {
+	if bytes.Contains(line, []byte("boom")) {
+		panic("boom")
+	}
+	return m.Matcher.Match(line)
+}
+
+func TestSearchPanic(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt": "needle\n",
+		"b.txt": "needle\nboom\nneedle\n",
+		"c.txt": "hay\nneedle\n",
+		"d.txt": "boom\n",
+	})
+	for _, countMode := range []CountMode{CountNone, CountLines} {
+		for _, threads := range []int{0, 1, 2} {
+			name := fmt.Sprintf("count mode %v, threads-cpu %d", countMode, threads)
+			opts, rep := testOptions(t, "needle", func(o *SearchOptions) {
+				o.Workers = 2
+				o.CountMode = countMode
+				if threads > 0 {
+					o.CPUSlots = make(cpuSlots, threads)
+				}
+			})
+			opts.Matcher = panicMatcher{opts.Matcher}
+			// A CPU slot kept by a panicking file would stall the
+			// files after it for good.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				parallelListAndRead(context.Background(), []string{root}, opts)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("%s: the search hangs after the panics", name)
+			}
+
+			// The other files are searched in full.
+			a, c := filepath.Join(root, "a.txt"), filepath.Join(root, "c.txt")
+			if rep.ends[a] != 1 || rep.ends[c] != 1 {
+				t.Errorf("%s: end counts %v, want 1 for a.txt and c.txt", name, rep.ends)
+			}
+			// Each panic is an error of the file it happened in.
+			var paths []string
+			for _, e := range rep.errors {
+				if e.Kind != ErrorInternal {
+					t.Errorf("%s: error %v, want kind %v", name, e, ErrorInternal)
+				}
+				paths = append(paths, filepath.Base(e.Path))
+			}
+			if got := strings.Join(sorted(paths), " "); got != "b.txt d.txt" {
+				t.Errorf("%s: errors for %s, want b.txt d.txt", name, got)
+			}
+			if n := opts.Errors.count(); n != 2 {
+				t.Errorf("%s: %d errors counted, want 2", name, n)
+			}
+		}
+	}
+}
+
+// runSearch runs the search command with args, with standard input at the
+// null device, and returns what it wrote to stdout and stderr.
+func runSearch(t testing.TB, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	return runCommand(t, append([]string{"search"}, args...)...)
+}
+
+// runCommand runs findme with args, the command first, as runSearch does.
+func runCommand(t testing.TB, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	dir := t.TempDir()
+	outFile, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	errFile, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer errFile.Close()
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+
+	// The run sets the colors for its output; the next test starts over.
+	enable := color.Enable
+	defer func() { color.Enable = enable }()
+	oldStdout, oldStderr, oldStdin := os.Stdout, os.Stderr, os.Stdin
+	os.Stdout, os.Stderr, os.Stdin = outFile, errFile, null
+	err = newApp().RunContext(context.Background(), append([]string{"findme"}, args...))
+	os.Stdout, os.Stderr, os.Stdin = oldStdout, oldStderr, oldStdin
+
+	out, rerr := os.ReadFile(outFile.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	msgs, rerr := os.ReadFile(errFile.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(out), string(msgs), err
+}
+
+func TestSearchCommand(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":     "one needle\ntwo\n",
+		"sub/b.txt": "needle two\n",
+	})
+	stdout, _, err := runSearch(t, "-d", root, "-q", "needle", "-R", "-n", "--sort-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "a.txt") + ":1:one needle\n" + filepath.Join(root, "sub", "b.txt") + ":1:needle two\n"
+	if stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+}
+
+func TestCountTotalOutput(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":       "needle needle\nhay\nneedle\n",
+		"sub/b.txt":   "a needle\n",
+		"c.txt":       "hay\n",
+		"all.lst":     "a.txt\nsub/b.txt\nmissing.txt\n",
+		"nothing.lst": "c.txt\nmissing.txt\n",
+	})
+	chdir(t, root)
+	tests := []struct {
+		name   string
+		args   []string
+		stdout string
+		status int
+	}{
+		// A line with two hits counts once.
+		{"matches", []string{"-d", ".", "-R"}, "3\n", 0},
+		{"no match", []string{"-d", "c.txt"}, "0\n", 0},
+		{"error", []string{"--files-from", "all.lst"}, "3\n", exitTrouble},
+		{"error and no match", []string{"--files-from", "nothing.lst"}, "0\n", exitTrouble},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, err := runSearch(t, append([]string{"-q", "needle", "--count-total"}, tt.args...)...)
+			if got := exitCode(err); got != tt.status {
+				t.Errorf("exit status %d (%v), want %d", got, err, tt.status)
+			}
+			// The messages go to stderr, so stdout stays one number.
+			if stdout != tt.stdout {
+				t.Errorf("stdout = %q, want %q (stderr %q)", stdout, tt.stdout, stderr)
+			}
+		})
+	}
+}
+
+// processText runs Process over text as the file f and returns the matches.
+func processText(t testing.TB, text string, opts *SearchOptions, rep *captureReporter) []Match {
+	t.Helper()
+	if err := Process(context.Background(), bufio.NewReader(strings.NewReader(text)), "f", opts); err != nil {
+		t.Fatal(err)
+	}
+	return rep.matches
+}
+
+func TestLineEndings(t *testing.T) {
+	type pos struct {
+		line   int
+		offset int64
+		column int
+		text   string
+	}
+	tests := []struct {
+		name string
+		text string
+		want []pos
+	}{
+		{"lf", "one\ntwo foo\nfoo\nlast foo\n", []pos{{2, 4, 5, "two foo"}, {3, 12, 1, "foo"}, {4, 16, 6, "last foo"}}},
+		{"crlf", "one\r\ntwo foo\r\nfoo\r\nlast foo\r\n", []pos{{2, 5, 5, "two foo"}, {3, 14, 1, "foo"}, {4, 19, 6, "last foo"}}},
+		{"mixed", "one\r\ntwo foo\nfoo\r\nlast foo", []pos{{2, 5, 5, "two foo"}, {3, 13, 1, "foo"}, {4, 18, 6, "last foo"}}},
+		{"lone cr", "one\rtwo foo\n", []pos{{1, 0, 9, "one\rtwo foo"}}},
+	}
+	queries := []struct {
+		name string
+		edit func(*SearchOptions)
+	}{
+		{"literal", nil},
+		{"ignore case", func(o *SearchOptions) { o.CaseInsensitive = true }},
+		{"regex", func(o *SearchOptions) { o.Query, o.Regex = "fo+", true }},
+	}
+	for _, tt := range tests {
+		for _, q := range queries {
+			t.Run(tt.name+"/"+q.name, func(t *testing.T) {
+				opts, rep := testOptions(t, "foo", q.edit)
+				var got []pos
+				for _, m := range processText(t, tt.text, opts, rep) {
+					got = append(got, pos{m.Line, m.Offset, matchColumn(opts, m), m.Text})
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCountChunk(t *testing.T) {
+	has := func(line []byte) bool { return bytes.Contains(line, []byte("x")) }
+	tests := []struct {
+		data  string
+		delim byte
+		want  int
+	}{
+		{"", '\n', 0},
+		{"x\n", '\n', 1},
+		{"x\ny\nx", '\n', 2},
+		{"x\r\n\r\nax\r\n", '\n', 2},
+		{"x\r\r\n", '\n', 1},
+		{"\n\n", '\n', 0},
+		{"x\x00y\x00x", 0, 2},
+		{"x\ny\x00x", 0, 2},
+	}
+	for _, tt := range tests {
+		if got := countChunk(context.Background(), []byte(tt.data), tt.delim, has); got != tt.want {
+			t.Errorf("countChunk(%q, %q) = %d, want %d", tt.data, tt.delim, got, tt.want)
+		}
+	}
+
+	data := benchText(1 << 20)
+	if allocs := testing.AllocsPerRun(10, func() { countChunk(context.Background(), data, '\n', has) }); allocs != 0 {
+		t.Errorf("countChunk allocates %v times per chunk", allocs)
+	}
+}
+
+// TestCountFastPath checks that the counting modes, which count lines
+// without building results, select the same lines as a search reports.
+func TestCountFastPath(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 30000; i++ {
+		switch {
+		case i%5 == 0:
+			fmt.Fprintf(&text, "%d Needle\r\n", i)
+		case i%3 == 0:
+			fmt.Fprintf(&text, "%d needles and a needle\n", i)
+		default:
+			fmt.Fprintf(&text, "%d hay\n", i)
+		}
+	}
+	queries := []struct {
+		name string
+		edit func(*SearchOptions)
+	}{
+		{"literal", nil},
+		{"ignore case", func(o *SearchOptions) { o.CaseInsensitive = true }},
+		{"whole word", func(o *SearchOptions) { o.WholeWord = true }},
+		{"regex", func(o *SearchOptions) { o.Query, o.Regex = `needles?\b`, true }},
+		{"invert", func(o *SearchOptions) { o.Invert = true }},
+		{"line length", func(o *SearchOptions) { o.LineLengths = newLineLengths(0, 24, ColumnByte, false) }},
+	}
+	modes := []struct {
+		mode CountMode
+		want func(lines int) int
+	}{
+		{CountLines, func(lines int) int { return lines }},
+		{CountFilesWith, func(lines int) int { return min(lines, 1) }},
+		{CountFilesWithout, func(lines int) int { return min(lines, 1) }},
+		{CountTotal, func(lines int) int { return lines }},
+	}
+	for _, q := range queries {
+		opts, rep := testOptions(t, "needle", q.edit)
+		lines := len(processText(t, text.String(), opts, rep))
+		if lines == 0 {
+			t.Fatalf("%s: no lines reported", q.name)
+		}
+		for _, m := range modes {
+			opts, rep := testOptions(t, "needle", func(o *SearchOptions) {
+				if q.edit != nil {
+					q.edit(o)
+				}
+				o.CountMode = m.mode
+			})
+			processText(t, text.String(), opts, rep)
+			// The files-with modes stop at the first match.
+			if got, want := rep.counts["f"], m.want(lines); got != want && !(m.mode.stopsAtFirstMatch() && got >= want) {
+				t.Errorf("%s, count mode %d: counted %d, want %d", q.name, m.mode, got, want)
+			}
+			if len(rep.matches) != 0 {
+				t.Errorf("%s, count mode %d: %d matches reported", q.name, m.mode, len(rep.matches))
+			}
+		}
+	}
+}
+
+// benchText returns about size bytes of log-like lines, one in every 50
+// with "needle".
+func benchText(size int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < size; i++ {
+		if i%50 == 0 {
+			fmt.Fprintf(&b, "2026-01-01 12:00:%02d INFO request %d found a needle in the stack\n", i%60, i)
+		} else {
+			fmt.Fprintf(&b, "2026-01-01 12:00:%02d INFO request %d served from the cache in %dms\n", i%60, i, i%97)
+		}
+	}
+	return b.Bytes()
+}
+
+// benchProcess runs Process over data b.N times with opts.
+func benchProcess(b *testing.B, data []byte, opts *SearchOptions) {
+	b.Helper()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Process(context.Background(), bufio.NewReader(bytes.NewReader(data)), "f", opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	data := benchText(8 << 20)
+	for _, bb := range []struct {
+		name string
+		edit func(*SearchOptions)
+	}{
+		{"literal", nil},
+		{"ignore-case", func(o *SearchOptions) { o.CaseInsensitive = true }},
+		{"regex", func(o *SearchOptions) { o.Query, o.Regex = `need+le`, true }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			opts, _ := testOptions(b, "needle", func(o *SearchOptions) {
+				if bb.edit != nil {
+					bb.edit(o)
+				}
+				o.CountMode = CountLines
+				o.Reporter = discardReporter{}
+			})
+			benchProcess(b, data, opts)
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	data := benchText(8 << 20)
+	for _, bb := range []struct {
+		name string
+		edit func(*SearchOptions)
+	}{
+		{"literal", nil},
+		{"ignore-case", func(o *SearchOptions) { o.CaseInsensitive = true }},
+		{"regex", func(o *SearchOptions) { o.Query, o.Regex = `need+le`, true }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			opts, _ := testOptions(b, "needle", func(o *SearchOptions) {
+				if bb.edit != nil {
+					bb.edit(o)
+				}
+				o.Reporter = discardReporter{}
+			})
+			benchProcess(b, data, opts)
+		})
+	}
+}
+
+// discardReporter drops every result, so a benchmark measures the search.
+type discardReporter struct{}
+
+func (discardReporter) Match(Match)       {}
+func (discardReporter) Count(string, int) {}
+func (discardReporter) Close() error      { return nil }
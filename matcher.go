@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is the byte range [Start, End) of a match within a line.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Matcher finds the query in a line. Implementations are shared by the chunk
+// workers and must be safe for concurrent use.
+type Matcher interface {
+	// Match returns the non-overlapping matches in line from left to
+	// right, or nil when there is none.
+	Match(line []byte) []Span
+}
+
+// LineMatcher is implemented by the Matchers that can tell whether a line
+// matches without finding where. The count-only modes use it, so a matching
+// line costs them no spans.
+type LineMatcher interface {
+	MatchLine(line []byte) bool
+}
+
+// matchesLine reports whether m matches line, through MatchLine when m has
+// it.
+func matchesLine(m Matcher, line []byte) bool {
+	if lm, ok := m.(LineMatcher); ok {
+		return lm.MatchLine(line)
+	}
+	return m.Match(line) != nil
+}
+
+// NewMatcher returns the Matcher for the query and flags in opts, combined
+// with the --and and --not patterns.
+func NewMatcher(opts *SearchOptions) (Matcher, error) {
+	m, err := newQueryMatcher(opts)
+	if err != nil || (len(opts.And) == 0 && len(opts.Not) == 0) {
+		return m, err
+	}
+
+	pm := &PredicateMatcher{Matcher: m}
+	for _, pattern := range opts.And {
+		sub, err := newPatternMatcher(opts, pattern)
+		if err != nil {
+			return nil, err
+		}
+		pm.And = append(pm.And, sub)
+	}
+	for _, pattern := range opts.Not {
+		sub, err := newPatternMatcher(opts, pattern)
+		if err != nil {
+			return nil, err
+		}
+		pm.Not = append(pm.Not, sub)
+	}
+	return pm, nil
+}
+
+// newPatternMatcher returns the Matcher for a single pattern, e.g. of --and
+// or --not, read with the same flags as the query.
+func newPatternMatcher(opts *SearchOptions, pattern string) (Matcher, error) {
+	sub := *opts
+	sub.Query = pattern
+	sub.Patterns = nil
+	if opts.Regex {
+		if opts.CaseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %v", pattern, err)
+		}
+		sub.Re = re
+	}
+	return newQueryMatcher(&sub)
+}
+
+func newQueryMatcher(opts *SearchOptions) (Matcher, error) {
+	query := []byte(opts.Query)
+	if opts.CaseInsensitive && !opts.Regex {
+		query = foldCase(query, true)
+	}
+
+	switch {
+	case len(opts.Patterns) > 1 && !opts.Regex:
+		patterns := make([][]byte, len(opts.Patterns))
+		for i, p := range opts.Patterns {
+			patterns[i] = foldCase([]byte(p), opts.CaseInsensitive)
+		}
+		m := NewAhoCorasickMatcher(patterns, opts.CaseInsensitive)
+		m.WholeWord, m.IsWord = opts.WholeWord, opts.WordChars
+		return m, nil
+	case opts.Regex:
+		if opts.Re == nil {
+			return nil, fmt.Errorf("invalid regular expression %q", opts.Query)
+		}
+		return &RegexMatcher{Re: opts.Re}, nil
+	case opts.Fuzzy > 0:
+		return &FuzzyMatcher{Query: string(query), K: opts.Fuzzy, Fold: opts.CaseInsensitive}, nil
+	case opts.WholeWord:
+		return NewWholeWordMatcher(query, opts.WordChars, opts.CaseInsensitive)
+	default:
+		return &LiteralMatcher{Query: query, Fold: opts.CaseInsensitive}, nil
+	}
+}
+
+// PredicateMatcher reports the matches of Matcher on the lines that every
+// And matcher and no Not matcher accepts.
+type PredicateMatcher struct {
+	Matcher
+	And []Matcher
+	Not []Matcher
+}
+
+func (m *PredicateMatcher) Match(line []byte) []Span {
+	for _, not := range m.Not {
+		if not.Match(line) != nil {
+			return nil
+		}
+	}
+	for _, and := range m.And {
+		if and.Match(line) == nil {
+			return nil
+		}
+	}
+	return m.Matcher.Match(line)
+}
+
+func (m *PredicateMatcher) MatchLine(line []byte) bool {
+	for _, not := range m.Not {
+		if matchesLine(not, line) {
+			return false
+		}
+	}
+	for _, and := range m.And {
+		if !matchesLine(and, line) {
+			return false
+		}
+	}
+	return matchesLine(m.Matcher, line)
+}
+
+// foldCase lowercases line for case-insensitive matchers. The query is
+// lowercased once up front. Characters whose lowercase form has another
+// UTF-8 length are kept as they are, so that offsets into the folded line
+// are offsets into line too.
+func foldCase(line []byte, fold bool) []byte {
+	if !fold {
+		return line
+	}
+	folded := bytes.ToLower(line)
+	if len(folded) == len(line) {
+		return folded
+	}
+	folded = make([]byte, 0, len(line))
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRune(line[i:])
+		if lower := unicode.ToLower(r); r != utf8.RuneError && utf8.RuneLen(lower) == size {
+			folded = utf8.AppendRune(folded, lower)
+		} else {
+			folded = append(folded, line[i:i+size]...)
+		}
+		i += size
+	}
+	return folded
+}
+
+// LiteralMatcher finds a fixed string. An empty query matches every line.
+type LiteralMatcher struct {
+	Query []byte
+	Fold  bool
+}
+
+func (m *LiteralMatcher) Match(line []byte) []Span {
+	if len(m.Query) == 0 {
+		return []Span{{0, 0}}
+	}
+	line, index := m.prepare(line)
+	var spans []Span
+	for from := 0; ; {
+		i := index(line[from:], m.Query)
+		if i < 0 {
+			return spans
+		}
+		start := from + i
+		from = start + len(m.Query)
+		spans = append(spans, Span{start, from})
+	}
+}
+
+func (m *LiteralMatcher) MatchLine(line []byte) bool {
+	line, index := m.prepare(line)
+	return index(line, m.Query) >= 0
+}
+
+// prepare returns line, folded when needed, and the function that finds
+// the query in it.
+func (m *LiteralMatcher) prepare(line []byte) ([]byte, func(s, sep []byte) int) {
+	if !m.Fold {
+		return line, bytes.Index
+	}
+	// The common case of ASCII text is folded on the fly.
+	if isASCII(m.Query) && isASCII(line) {
+		return line, indexFoldASCII
+	}
+	return foldCase(line, true), bytes.Index
+}
+
+// RegexMatcher finds a regular expression.
+type RegexMatcher struct {
+	Re *regexp.Regexp
+}
+
+func (m *RegexMatcher) Match(line []byte) []Span {
+	locs := m.Re.FindAllIndex(line, -1)
+	if locs == nil {
+		return nil
+	}
+	spans := make([]Span, len(locs))
+	for i, loc := range locs {
+		spans[i] = Span{loc[0], loc[1]}
+	}
+	return spans
+}
+
+func (m *RegexMatcher) MatchLine(line []byte) bool {
+	return m.Re.Match(line)
+}
+
+// WholeWordMatcher finds a fixed string that is not part of a longer word.
+// Without IsWord the word edges are those of the regexp \b; with it, the
+// characters around a match must not be word characters.
+type WholeWordMatcher struct {
+	Query  []byte
+	IsWord func(rune) bool
+	Fold   bool
+	re     *regexp.Regexp
+}
+
+// NewWholeWordMatcher returns a WholeWordMatcher for query, which is already
+// lowercased when fold is set.
+func NewWholeWordMatcher(query []byte, isWord func(rune) bool, fold bool) (*WholeWordMatcher, error) {
+	m := &WholeWordMatcher{Query: query, IsWord: isWord, Fold: fold}
+	if isWord == nil {
+		re, err := compileRegexp(wholeWordPattern(string(query)))
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m *WholeWordMatcher) Match(line []byte) []Span {
+	line = foldCase(line, m.Fold)
+	if m.re != nil {
+		return (&RegexMatcher{Re: m.re}).Match(line)
+	}
+	var spans []Span
+	for from := 0; ; {
+		start, end := wordIndex(line, m.Query, from, m.IsWord)
+		if start < 0 {
+			return spans
+		}
+		spans = append(spans, Span{start, end})
+		from = end
+	}
+}
+
+func (m *WholeWordMatcher) MatchLine(line []byte) bool {
+	line = foldCase(line, m.Fold)
+	if m.re != nil {
+		return m.re.Match(line)
+	}
+	start, _ := wordIndex(line, m.Query, 0, m.IsWord)
+	return start >= 0
+}
+
+// FuzzyMatcher finds substrings within K edits of a fixed string.
+type FuzzyMatcher struct {
+	Query string
+	K     int
+	Fold  bool
+}
+
+func (m *FuzzyMatcher) Match(line []byte) []Span {
+	s := string(foldCase(line, m.Fold))
+	var spans []Span
+	for from := 0; from <= len(s); {
+		start, end := fuzzyIndex(s[from:], m.Query, m.K)
+		if start < 0 {
+			return spans
+		}
+		spans = append(spans, Span{from + start, from + end})
+		if end == start {
+			// A query within K edits of nothing matches everywhere;
+			// once is enough.
+			return spans
+		}
+		from += end
+	}
+	return spans
+}
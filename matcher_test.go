@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatcherSpans(t *testing.T) {
+	identifier := func(o *SearchOptions) {
+		o.WholeWord = true
+		o.WordChars, _ = NewWordChars(WordBoundaryIdentifier, "")
+	}
+	tests := []struct {
+		name  string
+		query string
+		edit  func(*SearchOptions)
+		line  string
+		want  []Span
+	}{
+		{"literal", "ab", nil, "xabyab", []Span{{1, 3}, {4, 6}}},
+		{"literal none", "ab", nil, "xAByaB", nil},
+		{"literal no overlap", "aa", nil, "aaaa a", []Span{{0, 2}, {2, 4}}},
+		{"empty query", "", nil, "anything", []Span{{0, 0}}},
+		{"ignore case", "ab", func(o *SearchOptions) { o.CaseInsensitive = true }, "xAByaB", []Span{{1, 3}, {4, 6}}},
+		// É folds to é of the same length, so the spans stay byte
+		// offsets into the line.
+		{"ignore case utf-8", "été", func(o *SearchOptions) { o.CaseInsensitive = true }, "un ÉTÉ chaud", []Span{{3, 8}}},
+		{"regex", `a+b`, func(o *SearchOptions) { o.Regex = true }, "ab aaab b", []Span{{0, 2}, {3, 7}}},
+		{"regex ignore case", `a+b`, func(o *SearchOptions) { o.Regex, o.CaseInsensitive = true, true }, "AaB", []Span{{0, 3}}},
+		{"whole word", "foo", func(o *SearchOptions) { o.WholeWord = true }, "foo foobar (foo) foo_", []Span{{0, 3}, {12, 15}}},
+		{"whole word identifier", "foo", identifier, "foo foo-bar foo_x $foo", []Span{{0, 3}, {4, 7}, {19, 22}}},
+		{"whole word ignore case", "foo", func(o *SearchOptions) { o.WholeWord, o.CaseInsensitive = true, true }, "FOO fOo1 Foo", []Span{{0, 3}, {9, 12}}},
+		{"patterns", "", func(o *SearchOptions) { o.Patterns = []string{"he", "she", "hers"} }, "ushers", []Span{{1, 4}}},
+		{"patterns ignore case", "", func(o *SearchOptions) { o.Patterns, o.CaseInsensitive = []string{"cat", "dog"}, true }, "Dog, CAT", []Span{{0, 3}, {5, 8}}},
+		{"patterns whole word", "", func(o *SearchOptions) { o.Patterns, o.WholeWord = []string{"cat", "dog"}, true }, "cats dog", []Span{{5, 8}}},
+		{"and", "foo", func(o *SearchOptions) { o.And = []string{"bar"} }, "foo bar foo", []Span{{0, 3}, {8, 11}}},
+		{"and missing", "foo", func(o *SearchOptions) { o.And = []string{"bar"} }, "foo baz", nil},
+		{"not", "foo", func(o *SearchOptions) { o.Not = []string{"bar"} }, "foo bar", nil},
+		{"not absent", "foo", func(o *SearchOptions) { o.Not = []string{"bar"} }, "foo baz", []Span{{0, 3}}},
+		{"fuzzy", "hello", func(o *SearchOptions) { o.Fuzzy = 1 }, "say helo", []Span{{4, 8}}},
+		{"fuzzy too far", "hello", func(o *SearchOptions) { o.Fuzzy = 1 }, "say hi", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, _ := testOptions(t, tt.query, tt.edit)
+			if got := opts.Matcher.Match([]byte(tt.line)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+			if got := matchesLine(opts.Matcher, []byte(tt.line)); got != (tt.want != nil) {
+				t.Errorf("matchesLine(%q) = %v, want %v", tt.line, got, tt.want != nil)
+			}
+		})
+	}
+}
+
+// lineMatchers are the queries and flags of the matchers the count-only
+// modes check lines with.
+var lineMatchers = []struct {
+	name, query string
+	edit        func(*SearchOptions)
+}{
+	{"literal", "needle", nil},
+	{"ignore case", "NEEDLE", func(o *SearchOptions) { o.CaseInsensitive = true }},
+	{"regex", `ne+dle`, func(o *SearchOptions) { o.Regex = true }},
+	{"whole word", "needle", func(o *SearchOptions) { o.WholeWord, o.WordChars = true, isIdentifierRune }},
+	{"patterns", "", func(o *SearchOptions) { o.Patterns = []string{"needle", "stack"} }},
+	{"not", "needle", func(o *SearchOptions) { o.Not = []string{"hay"} }},
+}
+
+func TestMatchLineAllocs(t *testing.T) {
+	hit := []byte("2026-01-01 12:00:00 INFO request 50 found a needle in the stack")
+	for _, lm := range lineMatchers {
+		opts, _ := testOptions(t, lm.query, lm.edit)
+		if _, ok := opts.Matcher.(LineMatcher); !ok {
+			t.Errorf("%s: %T has no MatchLine", lm.name, opts.Matcher)
+			continue
+		}
+		if !matchesLine(opts.Matcher, hit) {
+			t.Errorf("%s: no match in %q", lm.name, hit)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { matchesLine(opts.Matcher, hit) }); allocs != 0 {
+			t.Errorf("%s: %v allocations per matching line", lm.name, allocs)
+		}
+	}
+}
+
+// BenchmarkMatchLine compares the check of a matching line with finding its
+// spans.
+func BenchmarkMatchLine(b *testing.B) {
+	hit := []byte("2026-01-01 12:00:00 INFO request 50 found a needle in the stack")
+	for _, lm := range lineMatchers {
+		opts, _ := testOptions(b, lm.query, lm.edit)
+		b.Run(lm.name+"/spans", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				opts.Matcher.Match(hit)
+			}
+		})
+		b.Run(lm.name+"/line", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				matchesLine(opts.Matcher, hit)
+			}
+		})
+	}
+}
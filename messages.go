@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync/atomic"
+)
+
+// Kinds of FileError.
+const (
+	ErrorNotFound   = "not_found"
+	ErrorPermission = "permission"
+	ErrorMalformed  = "malformed"
+	ErrorRead       = "read"
+	ErrorList       = "list"
+	ErrorReplace    = "replace"
+
+	// ErrorInternal is a panic recovered while searching a file.
+	ErrorInternal = "internal"
+
+	// SkipBinary marks an archive entry left out because it looks binary.
+	// It is not an error and does not count towards the exit status.
+	SkipBinary = "binary"
+)
+
+// FileError is a file that could not be read or searched, or was skipped,
+// with a Kind a program can act on without parsing the message.
+type FileError struct {
+	Path string
+	Kind string
+	Err  error
+}
+
+// kind refines Kind by the cause of Err, so a permission problem is reported
+// as such wherever it happened.
+func (e FileError) kind() string {
+	switch {
+	case errors.Is(e.Err, fs.ErrPermission):
+		return ErrorPermission
+	case errors.Is(e.Err, fs.ErrNotExist):
+		return ErrorNotFound
+	}
+	return e.Kind
+}
+
+// ErrorEvents is implemented by reporters that report failed and skipped
+// files in their own output, as the --events stream does.
+type ErrorEvents interface {
+	FileError(e FileError)
+	FileSkipped(e FileError)
+}
+
+// fileErrors reports the files that could not be read or searched. Each
+// error counts towards the exit status; with quiet, set by --no-messages,
+// the message itself is dropped. A nil *fileErrors prints every message and
+// counts nothing.
+type fileErrors struct {
+	quiet bool
+	n     atomic.Int64
+
+	// events, when set, also receives every error and skipped file as a
+	// FileError, whether or not the messages are dropped.
+	events ErrorEvents
+}
+
+// report counts the error e and prints it to stderr, formatted, followed by
+// a newline, unless messages are suppressed.
+func (e *fileErrors) report(fe FileError, format string, args ...interface{}) {
+	if e != nil {
+		e.n.Add(1)
+		if e.events != nil {
+			fe.Kind = fe.kind()
+			e.events.FileError(fe)
+		}
+		if e.quiet {
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// skip notes a file that was left out on purpose. It is only reported to
+// events and does not count as an error.
+func (e *fileErrors) skip(fe FileError) {
+	if e != nil && e.events != nil {
+		e.events.FileSkipped(fe)
+	}
+}
+
+// count returns the number of errors reported.
+func (e *fileErrors) count() int64 {
+	if e == nil {
+		return 0
+	}
+	return e.n.Load()
+}
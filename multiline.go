@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// multilineMaxBytes caps the size of a file searched with --multiline, which
+// needs the whole file in memory. Larger files are skipped with a warning.
+const multilineMaxBytes = 64 << 20
+
+// processMultiline searches the whole content of reader at once so that the
+// regular expression can match across lines. Each match is reported at the
+// line it starts on, with Text holding every line it touches.
+func processMultiline(ctx context.Context, reader *bufio.Reader, fileName string, opts *SearchOptions) error {
+	data, err := io.ReadAll(io.LimitReader(reader, multilineMaxBytes+1))
+	if err != nil {
+		opts.Errors.report(FileError{Path: fileName, Kind: ErrorRead, Err: err}, "Error reading %s: %v", fileName, err)
+		return nil
+	}
+	if len(data) > multilineMaxBytes {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s, larger than %d MiB (--multiline)\n", fileName, multilineMaxBytes>>20)
+		opts.Stats.skipped()
+		return nil
+	}
+	data, _ = opts.ByteLimit.take(data, '\n')
+
+	events, _ := opts.Reporter.(FileEvents)
+	if events != nil {
+		events.Begin(fileName)
+	}
+
+	n := 0
+	line, lineStart := 1, 0
+	for _, loc := range opts.Re.FindAllIndex(data, -1) {
+		if ctx.Err() != nil {
+			break
+		}
+		if opts.MaxCount > 0 && n == opts.MaxCount {
+			break
+		}
+		if opts.CountMode.stopsAtFirstMatch() && n > 0 {
+			break
+		}
+		n++
+		if opts.countOnly() {
+			continue
+		}
+
+		// Move to the line holding the start of the match.
+		for {
+			i := bytes.IndexByte(data[lineStart:], '\n')
+			if i < 0 || lineStart+i >= loc[0] {
+				break
+			}
+			lineStart += i + 1
+			line++
+		}
+		lineEnd := len(data)
+		if i := bytes.IndexByte(data[loc[1]:], '\n'); i >= 0 {
+			lineEnd = loc[1] + i
+		}
+		if loc[1] > loc[0] && data[loc[1]-1] == '\n' {
+			// The match ends with a newline; do not show the next line.
+			lineEnd = loc[1] - 1
+		}
+		text := bytes.TrimRight(data[lineStart:lineEnd], "\r")
+		end := min(loc[1]-lineStart, len(text))
+		opts.Reporter.Match(Match{Path: fileName, Line: line, Offset: int64(lineStart), Text: string(text), Start: loc[0] - lineStart, End: end, Spans: []Span{{loc[0] - lineStart, end}}})
+	}
+
+	if events != nil {
+		events.End(fileName, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts.Stats.searched(int64(len(data)), n)
+	if opts.countOnly() {
+		opts.Reporter.Count(fileName, n)
+	}
+	return nil
+}
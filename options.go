@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// SearchOptions carries the settings of a single search run from the command
+// line down to the walker, reader and matcher stages.
+type SearchOptions struct {
+	Query           string
+	Regex           bool
+	Re              *regexp.Regexp
+	CaseInsensitive bool
+	WholeWord       bool
+
+	// Patterns are the query and the --patterns-file patterns. A line
+	// matches if it contains any of them. With Regex, Query already holds
+	// their alternation.
+	Patterns []string
+
+	// WordChars, when set, decides which characters may not surround a
+	// --whole-word match; nil means the regexp \b.
+	WordChars func(rune) bool
+
+	// Fuzzy, when positive, matches the query within that many edits.
+	Fuzzy int
+
+	// Multiline searches whole files so that regex matches can span lines.
+	Multiline bool
+
+	// And and Not are further patterns that a line must, or must not,
+	// match as well. They are read with the same flags as Query.
+	And []string
+	Not []string
+
+	// Invert selects the lines that do not match.
+	Invert bool
+
+	// Matcher finds the query in a line; NewMatcher picks it from the
+	// flags above.
+	Matcher Matcher
+
+	// OnlyMatching reports every match of a line on its own and prints
+	// only the matched text.
+	OnlyMatching bool
+
+	// MaxMatchesPerLine caps the matches of a line reported with
+	// OnlyMatching; zero means no limit.
+	MaxMatchesPerLine int
+
+	// LineNumber and Column add the 1-based line and byte column of the
+	// first match to every reported line.
+	LineNumber bool
+	Column     bool
+
+	// LineLengths, when set, skips the lines outside --min-line-length
+	// and --max-line-length and, with --drop-invalid, those that are not
+	// valid UTF-8.
+	LineLengths *lineLengths
+
+	// Scopes, when set, gives each match the line that opens its enclosing
+	// function or section, by extension, for --context-scope.
+	Scopes ScopePatterns
+
+	// ColumnUnit is ColumnByte or ColumnRune.
+	ColumnUnit string
+
+	// WithFilename prefixes text output with the file name. With Heading,
+	// the name is printed once above the lines of each file instead.
+	WithFilename bool
+	Heading      bool
+
+	// NoHeader drops the header row of --format csv and tsv.
+	NoHeader bool
+
+	// PreviewLines, with Heading, prints at most that many results of each
+	// file and a note of how many more there are.
+	PreviewLines int
+
+	// ByteOffset adds the absolute byte offset of the first match.
+	ByteOffset bool
+
+	// CountMode replaces the matching lines with counts or file names.
+	CountMode CountMode
+
+	// IncludeZero also lists the files without matches with CountLines.
+	IncludeZero bool
+
+	// MaxCount stops reading a file after that many selected lines.
+	MaxCount int
+
+	// MaxColumns truncates printed lines to that many characters. Matching
+	// always sees the full line.
+	MaxColumns        int
+	MaxColumnsPreview bool
+
+	// Delimiter ends a line; it is '\n' unless --line-delimiter says
+	// otherwise, and line numbers then count records.
+	Delimiter byte
+
+	// Head and Tail restrict the search to the first or last that many lines
+	// of each file; zero means the whole file.
+	Head int
+	Tail int
+
+	// FromLine, set by --lines, skips the lines before it; the end of the
+	// range is Head.
+	FromLine int
+
+	// FileTimeout bounds the time spent on a single file; zero means none.
+	FileTimeout time.Duration
+
+	// Workers is the fixed number of file readers, or 0 for auto mode.
+	Workers int
+
+	// CPUSlots, set with --threads-cpu, bounds the chunks matched at once.
+	CPUSlots cpuSlots
+
+	// SortFiles searches one file, and one chunk, at a time so results come
+	// out in walk order.
+	SortFiles bool
+
+	// SearchZip searches the entries of .zip archives instead of the raw
+	// archive bytes.
+	SearchZip bool
+
+	// Text searches archive entries that look binary instead of skipping
+	// them.
+	Text bool
+
+	// SearchGit descends into .git directories.
+	SearchGit bool
+
+	// GitTracked lists the files of each root with git ls-files instead
+	// of walking it; GitUntracked adds the untracked files not ignored.
+	GitTracked   bool
+	GitUntracked bool
+
+	// FileList, when set, replaces the walk with the paths it lists,
+	// separated by FileListDelimiter.
+	FileList          io.Reader
+	FileListDelimiter byte
+
+	// WholeFile, when set, replaces the line by line search with a single
+	// match against the whole content of each file.
+	WholeFile *regexp.Regexp
+
+	// Errors reports the files that could not be searched.
+	Errors *fileErrors
+
+	// ByteLimit, when set, ends the scan once --max-bytes were read.
+	ByteLimit *byteLimit
+
+	// Visited, when set, skips files already queued under another path.
+	Visited *visitedFiles
+
+	// MaxDepth is the number of directory levels the walk descends below a
+	// root, or unlimitedDepth.
+	MaxDepth int
+
+	// Filter applies the --include, --exclude and --exclude-dir globs.
+	Filter *PathFilter
+
+	// Ignore skips paths below the search root while walking.
+	Ignore *IgnoreMatcher
+
+	// Reporter renders the matches found by the workers.
+	Reporter Reporter
+
+	// Colors styles the text format; nil means the default colors.
+	Colors *ColorScheme
+
+	// PathSeparator, when set, replaces the native separator in reported
+	// paths.
+	PathSeparator string
+
+	// AbsolutePath reports every path as an absolute one, wherever it came
+	// from: the walk, a --files-from list or an archive.
+	AbsolutePath bool
+
+	// Ordered, when set, is the Reporter wrapper that keeps results in the
+	// order files were found; the walker and readers notify it.
+	Ordered *OrderedReporter
+
+	// Replacer is set when the run rewrites matches instead of reporting them.
+	Replacer *Replacer
+
+	// Stats collects the totals of --summary-json; nil when not asked for.
+	Stats *RunStats
+}
+
+// countOnly reports whether the run only needs to know how many lines of each
+// file match, not what they contain.
+func (o *SearchOptions) countOnly() bool {
+	return o.CountMode != CountNone
+}
+
+// Units accepted by --column-unit.
+const (
+	ColumnByte = "byte"
+	ColumnRune = "rune"
+)
+
+// CountMode selects what the counting modes print instead of matching lines.
+type CountMode int
+
+const (
+	CountNone         CountMode = iota
+	CountLines                  // -c: the number of selected lines per file
+	CountFilesWith              // -l: the names of files with a selected line
+	CountFilesWithout           // -L: the names of files without one
+	CountTotal                  // --count-total: one total for the whole run
+)
+
+// NewCountMode returns the mode for the counting flags. They are mutually
+// exclusive.
+func NewCountMode(count, filesWith, filesWithout, total bool) (CountMode, error) {
+	mode := CountNone
+	set := 0
+	for _, f := range []struct {
+		on   bool
+		mode CountMode
+	}{{count, CountLines}, {filesWith, CountFilesWith}, {filesWithout, CountFilesWithout}, {total, CountTotal}} {
+		if f.on {
+			mode = f.mode
+			set++
+		}
+	}
+	if set > 1 {
+		return CountNone, fmt.Errorf("--count, --files-with-matches, --files-without-match and --count-total are mutually exclusive")
+	}
+	return mode, nil
+}
+
+// stopsAtFirstMatch reports whether a single selected line settles the
+// result for a whole file.
+func (m CountMode) stopsAtFirstMatch() bool {
+	return m == CountFilesWith || m == CountFilesWithout
+}
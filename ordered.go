@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// orderedBufferLimit caps the number of results held back for a single file.
+// When a file waiting its turn exceeds it, OrderedReporter gives up ordering
+// and streams everything from then on, so memory stays bounded.
+const orderedBufferLimit = 10000
+
+// OrderedReporter makes sure all results of a file are printed before those
+// of any file enqueued after it. Results of the oldest unfinished file are
+// passed straight through; later files are buffered until it is done. Only
+// files between the walker and the readers are pending at any time, so the
+// buffers form a small ring rather than the whole result set.
+type OrderedReporter struct {
+	mu        sync.Mutex
+	inner     Reporter
+	limit     int
+	queue     []*pendingFile
+	byPath    map[string]*pendingFile
+	streaming bool
+}
+
+type pendingFile struct {
+	path    string
+	done    bool
+	results []func()
+}
+
+// NewOrderedReporter wraps inner so results come out in enqueue order.
+func NewOrderedReporter(inner Reporter) *OrderedReporter {
+	return &OrderedReporter{
+		inner:  inner,
+		limit:  orderedBufferLimit,
+		byPath: make(map[string]*pendingFile),
+	}
+}
+
+// Enqueue registers path as the next file in output order. The walker calls
+// it before handing the file to a reader.
+func (r *OrderedReporter) Enqueue(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.streaming {
+		return
+	}
+	f := &pendingFile{path: path}
+	r.queue = append(r.queue, f)
+	r.byPath[path] = f
+}
+
+// Done marks path as fully searched and flushes every file whose turn has
+// come.
+func (r *OrderedReporter) Done(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.byPath[path]; ok {
+		f.done = true
+	}
+	r.advance()
+}
+
+func (r *OrderedReporter) Match(m Match) {
+	r.emit(m.Path, func() { r.inner.Match(m) })
+}
+
+func (r *OrderedReporter) Count(path string, n int) {
+	r.emit(path, func() { r.inner.Count(path, n) })
+}
+
+func (r *OrderedReporter) Begin(path string) {
+	if events, ok := r.inner.(FileEvents); ok {
+		r.emit(path, func() { events.Begin(path) })
+	}
+}
+
+func (r *OrderedReporter) End(path string, matches int) {
+	if events, ok := r.inner.(FileEvents); ok {
+		r.emit(path, func() { events.End(path, matches) })
+	}
+}
+
+func (r *OrderedReporter) Close() error {
+	r.mu.Lock()
+	r.flushAll()
+	r.mu.Unlock()
+	return r.inner.Close()
+}
+
+func (r *OrderedReporter) emit(path string, result func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.lookup(path)
+	if r.streaming || f == nil || f == r.queue[0] {
+		result()
+		return
+	}
+
+	f.results = append(f.results, result)
+	if len(f.results) > r.limit {
+		r.flushAll()
+	}
+}
+
+// lookup finds the pending file a result belongs to. Results from inside an
+// archive carry an archive!entry path and belong to the archive.
+func (r *OrderedReporter) lookup(path string) *pendingFile {
+	if f, ok := r.byPath[path]; ok {
+		return f
+	}
+	for i := range path {
+		if strings.HasPrefix(path[i:], zipEntrySeparator) {
+			if f, ok := r.byPath[path[:i]]; ok {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// advance drops finished files from the head of the queue, printing what they
+// buffered, and then lets the new head print its buffered results.
+func (r *OrderedReporter) advance() {
+	for len(r.queue) > 0 {
+		head := r.queue[0]
+		for _, result := range head.results {
+			result()
+		}
+		head.results = nil
+		if !head.done {
+			return
+		}
+		delete(r.byPath, head.path)
+		r.queue = r.queue[1:]
+	}
+}
+
+// flushAll prints every buffered result in order and switches to streaming.
+func (r *OrderedReporter) flushAll() {
+	for _, f := range r.queue {
+		for _, result := range f.results {
+			result()
+		}
+	}
+	r.queue = nil
+	r.byPath = make(map[string]*pendingFile)
+	r.streaming = true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// defaultOutputBufferSize is the output buffer used when standard output is
+// not a terminal. Terminals stay unbuffered so that results show up as soon
+// as they are found.
+const defaultOutputBufferSize = 64 * 1024
+
+// defaultOutputBuffer returns the --output-buffer-size default for f.
+func defaultOutputBuffer(f *os.File) int {
+	if isTerminal(f) {
+		return 0
+	}
+	return defaultOutputBufferSize
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// newOutput wraps w in a buffer of size bytes and returns it together with
+// the function that flushes it. A size of zero writes straight through.
+// Reporters serialize their writes, so the buffer needs no lock of its own.
+func newOutput(w io.Writer, size int) (io.Writer, func() error) {
+	if size <= 0 {
+		return w, func() error { return nil }
+	}
+	buf := bufio.NewWriterSize(w, size)
+	return buf, buf.Flush
+}
+
+// openOutput creates the --output file and returns the writer for the
+// results together with the function that finishes it: with compress the
+// results go through a gzip stream, and finishing writes its trailer before
+// the file is closed. Finishing must run on every exit, or the archive is
+// left truncated.
+func openOutput(path string, compress bool) (io.Writer, func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !compress {
+		return f, f.Close, nil
+	}
+	gz := gzip.NewWriter(f)
+	return gz, func() error {
+		err := gz.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
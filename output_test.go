@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewOutput(t *testing.T) {
+	var dst bytes.Buffer
+	w, flush := newOutput(&dst, 0)
+	io.WriteString(w, "now\n")
+	if dst.String() != "now\n" {
+		t.Errorf("unbuffered output holds %q", dst.String())
+	}
+	if err := flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.Reset()
+	w, flush = newOutput(&dst, 16)
+	io.WriteString(w, "held\n")
+	if dst.Len() != 0 {
+		t.Errorf("%q written before the buffer filled", dst.String())
+	}
+	io.WriteString(w, "more than sixteen bytes\n")
+	if dst.Len() == 0 {
+		t.Error("nothing written once the buffer filled")
+	}
+	if err := flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "held\nmore than sixteen bytes\n"; dst.String() != want {
+		t.Errorf("output = %q, want %q", dst.String(), want)
+	}
+}
+
+func TestDefaultOutputBuffer(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got := defaultOutputBuffer(f); got != defaultOutputBufferSize {
+		t.Errorf("buffer for a file = %d, want %d", got, defaultOutputBufferSize)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if got := defaultOutputBuffer(w); got != defaultOutputBufferSize {
+		t.Errorf("buffer for a pipe = %d, want %d", got, defaultOutputBufferSize)
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "needle\n"})
+	for _, compress := range []bool{false, true} {
+		out := filepath.Join(root, "results")
+		args := []string{"-d", root, "-q", "needle", "-h", "--output", out}
+		if compress {
+			args = append(args, "--output-gzip")
+		}
+		stdout, _, err := runSearch(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout != "" {
+			t.Errorf("stdout = %q, want nothing", stdout)
+		}
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if compress {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The results file is in the searched directory but not searched.
+		if string(got) != "needle\n" {
+			t.Errorf("compress %v: results file holds %q, want %q", compress, got, "needle\n")
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives name the owner and group of info as far as the user may.
+// Only root can hand a file to another user, but an owner can give it any
+// group they belong to. What cannot be kept stays with the user running
+// findme, as with sed -i.
+func chownLike(name string, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if err := os.Chown(name, int(st.Uid), int(st.Gid)); err != nil {
+		os.Chown(name, -1, int(st.Gid))
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// checkMode fails unless the permission and special bits of path are mode,
+// in the octal notation of chmod.
+func checkMode(t *testing.T, path string, mode uint32) {
+	t.Helper()
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	if got := uint32(st.Mode) & 0o7777; got != mode {
+		t.Errorf("mode = %#o, want %#o", got, mode)
+	}
+}
+
+func TestReplaceKeepsMode(t *testing.T) {
+	for _, mode := range []uint32{0o4755, 0o2640, 0o1644, 0o600} {
+		t.Run(fmt.Sprintf("%#o", mode), func(t *testing.T) {
+			path := filepath.Join(writeTree(t, map[string]string{"a.sh": "echo foo\n"}), "a.sh")
+			if err := syscall.Chmod(path, mode); err != nil {
+				t.Fatal(err)
+			}
+			checkMode(t, path, mode)
+			rp := testReplacer(t, "foo", ReplaceOptions{Replacement: "bar"}, nil)
+			if err := rp.ReplaceFile(context.Background(), path); err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, path, "echo bar\n")
+			checkMode(t, path, mode)
+		})
+	}
+}
+
+func TestReplaceKeepsOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can give a file to another user")
+	}
+	const uid, gid = 4321, 8765
+	path := filepath.Join(writeTree(t, map[string]string{"a.txt": "foo\n"}), "a.txt")
+	if err := os.Chown(path, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Chmod(path, 0o4755); err != nil {
+		t.Fatal(err)
+	}
+	rp := testReplacer(t, "foo", ReplaceOptions{Replacement: "bar"}, nil)
+	if err := rp.ReplaceFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != uid || st.Gid != gid {
+		t.Errorf("owner = %d:%d, want %d:%d", st.Uid, st.Gid, uid, gid)
+	}
+	// The chown comes before the chmod, so the setuid bit survives it.
+	checkMode(t, path, 0o4755)
+}
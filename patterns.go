@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readPatterns returns the patterns of a --patterns-file, one per line.
+// Empty lines are skipped, so a trailing blank line does not match
+// everything.
+func readPatterns(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if p := strings.TrimRight(scanner.Text(), "\r"); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// regexAlternation joins regex patterns into one that matches any of them.
+func regexAlternation(patterns []string) string {
+	var sb strings.Builder
+	for i, p := range patterns {
+		if i > 0 {
+			sb.WriteByte('|')
+		}
+		sb.WriteString("(?:")
+		sb.WriteString(p)
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
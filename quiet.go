@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// QuietReporter implements --quiet: it prints nothing and cancels the whole
+// scan at the first match, because one is enough to settle the exit status.
+type QuietReporter struct {
+	found  atomic.Bool
+	cancel context.CancelFunc
+}
+
+// NewQuietReporter returns a QuietReporter that ends the scan with cancel.
+func NewQuietReporter(cancel context.CancelFunc) *QuietReporter {
+	return &QuietReporter{cancel: cancel}
+}
+
+func (r *QuietReporter) Match(m Match) {
+	r.hit()
+}
+
+func (r *QuietReporter) Count(path string, n int) {
+	if n > 0 {
+		r.hit()
+	}
+}
+
+func (r *QuietReporter) hit() {
+	r.found.Store(true)
+	r.cancel()
+}
+
+// Found reports whether anything matched.
+func (r *QuietReporter) Found() bool {
+	return r.found.Load()
+}
+
+func (r *QuietReporter) Close() error { return nil }
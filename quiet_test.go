@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// exitCode returns the exit status the program ends with after err.
+func exitCode(err error) int {
+	var exit *exitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.code
+	default:
+		return 1
+	}
+}
+
+func TestQuietExitStatus(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":     "one needle\n",
+		"b.txt":     "nothing\n",
+		"found.lst": "missing.txt\na.txt\n",
+		"none.lst":  "missing.txt\nb.txt\n",
+	})
+	chdir(t, root)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"match", []string{"-d", "."}, 0},
+		{"no match", []string{"-d", "b.txt"}, exitNoMatch},
+		{"match and error", []string{"--files-from", "found.lst"}, 0},
+		{"error and no match", []string{"--files-from", "none.lst"}, exitTrouble},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSearch(t, append([]string{"-q", "needle", "--quiet", "--no-messages"}, tt.args...)...)
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exit status %d (%v), want %d", got, err, tt.want)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want nothing", stdout)
+			}
+		})
+	}
+}
+
+func TestQuietStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rep := NewQuietReporter(cancel)
+	opts, _ := testOptions(t, "needle", func(o *SearchOptions) {
+		o.Reporter = rep
+		o.CountMode = CountFilesWith
+	})
+	const limit = 10000
+	r := &lineReader{line: []byte("a needle\n"), limit: limit}
+	Process(ctx, bufio.NewReader(r), "f", opts)
+	if !rep.Found() || ctx.Err() == nil {
+		t.Error("the first match did not end the scan")
+	}
+	if reads := r.reads.Load(); reads >= limit/10 {
+		t.Errorf("Process read %d times after the first match", reads)
+	}
+}
+
+// BenchmarkQuiet measures --quiet on a file whose first line matches,
+// against a file that has to be read to the end.
+func BenchmarkQuiet(b *testing.B) {
+	rest := benchText(32 << 20)
+	for _, bb := range []struct {
+		name  string
+		first string
+	}{
+		{"first line", "a pin on the first line\n"},
+		{"no match", "nothing on the first line\n"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			data := append([]byte(bb.first), bytes.ReplaceAll(rest, []byte("needle"), []byte("thread"))...)
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				opts, _ := testOptions(b, "pin", func(o *SearchOptions) {
+					o.Reporter = NewQuietReporter(cancel)
+					o.CountMode = CountFilesWith
+				})
+				Process(ctx, bufio.NewReader(bytes.NewReader(data)), "f", opts)
+				cancel()
+			}
+		})
+	}
+}
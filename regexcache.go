@@ -0,0 +1,72 @@
+package main
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// regexCacheSize bounds the number of compiled expressions kept by
+// compileRegexp. Runs use a handful of patterns, so a small cache is enough
+// while a run with many generated patterns cannot grow it without limit.
+const regexCacheSize = 128
+
+// regexCache is a least-recently-used cache of compiled regular expressions
+// keyed by pattern. Flags such as (?i) are part of the pattern, so they are
+// part of the key too. A *regexp.Regexp is safe for concurrent use, so the
+// workers share the cached values.
+type regexCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *regexEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type regexEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRegexCache(size int) *regexCache {
+	return &regexCache{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// compile returns the compiled pattern, compiling it on a miss. Invalid
+// patterns are not cached.
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if e, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*regexEntry).re, nil
+	}
+	c.mu.Unlock()
+
+	// Compile outside the lock; two workers missing at once both compile
+	// and the second store wins, which is harmless.
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexEntry).re, nil
+	}
+	c.items[pattern] = c.order.PushFront(&regexEntry{pattern: pattern, re: re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*regexEntry).pattern)
+	}
+	return re, nil
+}
+
+var regexps = newRegexCache(regexCacheSize)
+
+// compileRegexp is regexp.Compile through the shared cache.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexps.compile(pattern)
+}
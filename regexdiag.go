@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+)
+
+// compileQuery compiles the --regex query. When it does not compile, the
+// error says what is likely wrong and how to fix it, as users often forget
+// that a query like foo( or C:\ is read as a regular expression.
+func compileQuery(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err == nil {
+		return re, nil
+	}
+	msg := fmt.Sprintf("invalid regular expression %q: %v", pattern, err)
+	var serr *syntax.Error
+	if errors.As(err, &serr) {
+		if hint := regexHint(serr.Code); hint != "" {
+			msg += "\n  " + hint
+		}
+	}
+	return nil, fmt.Errorf("%s\n  to search for the text as is, use --fixed-strings (-F) instead of --regex", msg)
+}
+
+// regexHint suggests a fix for the common mistakes behind a syntax error.
+func regexHint(code syntax.ErrorCode) string {
+	switch code {
+	case syntax.ErrMissingParen, syntax.ErrUnexpectedParen:
+		return `parentheses group in a regex; write \( and \) to match them literally`
+	case syntax.ErrMissingBracket:
+		return `[ starts a character class; write \[ to match a bracket literally`
+	case syntax.ErrTrailingBackslash:
+		return `the pattern ends in a lone backslash; write \\ to match a backslash`
+	case syntax.ErrInvalidEscape:
+		return `the backslash starts an escape RE2 does not know; write \\ to match a backslash`
+	case syntax.ErrMissingRepeatArgument:
+		return `*, + and ? repeat what comes before them; write \*, \+ or \? to match them literally`
+	case syntax.ErrInvalidRepeatOp:
+		return `repetition operators cannot follow each other, as in a** or a+*; escape the second one`
+	case syntax.ErrInvalidPerlOp:
+		return `lookahead, lookbehind and backreferences are not supported (RE2 syntax)`
+	case syntax.ErrInvalidRepeatSize:
+		return `a repeat count like {n,m} must be at most 1000 and n must not exceed m`
+	}
+	return ""
+}
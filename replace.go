@@ -0,0 +1,797 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+// ReplaceOptions configures a Replacer.
+type ReplaceOptions struct {
+	// Replacement is the text that replaces each match. In regex mode $1
+	// and ${name} expand capture groups.
+	Replacement string
+
+	// Pairs, when set, replace the matches of each Old with its New
+	// instead, in the PairOrder sequence or independently of each other.
+	Pairs     []ReplacePair
+	PairOrder string
+
+	// Limit caps the number of replacements per file, or per line when
+	// PerLine is set. Zero means no cap.
+	Limit   int
+	PerLine bool
+
+	// DryRun prints the changes instead of writing them.
+	DryRun bool
+
+	// BackupSuffix, when set, keeps a copy of each original file at
+	// <name><suffix>. An existing backup is only overwritten with Force.
+	BackupSuffix string
+	Force        bool
+
+	// Fsync flushes each rewritten file and its directory to disk before
+	// the replacement is reported, so it survives a crash.
+	Fsync bool
+
+	// PreserveTimestamps gives each rewritten file back the modification
+	// time it had before. By default a rewritten file is newer, as tools
+	// like make expect.
+	PreserveTimestamps bool
+
+	// Roots are the search roots. Files whose real path, after resolving
+	// symlinks, lies outside all of them are refused unless AllowOutside
+	// is set.
+	Roots        []string
+	AllowOutside bool
+
+	// Interactive asks on Prompt before each changed line, reading the
+	// answers from Input.
+	Interactive bool
+	Input       io.Reader
+	Prompt      io.Writer
+
+	// ChangesJSON, set with DryRun, collects the changes and writes them to
+	// Output as JSON on Close instead of printing a preview per file.
+	ChangesJSON bool
+
+	// Output receives the previews, the result of each file and the totals
+	// of the run. It defaults to os.Stdout.
+	Output io.Writer
+
+	// Scope, when set, limits the replacement to the spans it matches.
+	Scope *regexp.Regexp
+
+	// PreviewLimit, with DryRun or Interactive, stops after that many
+	// changed files; zero means no limit.
+	PreviewLimit int
+
+	// Verify re-reads every rewritten file and warns about lines the
+	// search still matches.
+	Verify bool
+
+	// Conflict decides what happens when matches of different patterns
+	// overlap on a changed line: ConflictFirst, the default, keeps the
+	// leftmost match and, among those starting at the same byte, the one
+	// of the pattern listed first; ConflictError aborts the file.
+	Conflict string
+
+	// Stats, when set, receives the totals for --summary-json.
+	Stats *RunStats
+
+	// UndoDir, when set, is where the originals of rewritten files are
+	// journaled for replace-undo. Dry runs write no journal.
+	UndoDir string
+}
+
+// Values of --conflict.
+const (
+	ConflictFirst = "first"
+	ConflictError = "error"
+)
+
+// Replacer rewrites the matches of a search in place.
+type Replacer struct {
+	ReplaceOptions
+	re      *regexp.Regexp
+	literal bool
+
+	// template is Replacement parsed for $1 expansion and case directives;
+	// literal replacements are inserted as is.
+	template replaceTemplate
+
+	// realRoots are the canonical forms of Roots.
+	realRoots []string
+
+	// confirm is set with Interactive.
+	confirm *confirmation
+
+	// changes is set with ChangesJSON.
+	changes *changeReport
+
+	// journal is set with UndoDir.
+	journal *undoJournal
+
+	// counting is set during the preview pass of --confirm-threshold, in
+	// which ReplaceFile only counts what it would change.
+	counting bool
+
+	// outMu serializes the writes of the readers to Output.
+	outMu sync.Mutex
+
+	// previews counts the changed files shown so far; more records that
+	// PreviewLimit cut the preview short.
+	previews atomic.Int64
+	more     atomic.Bool
+
+	// filesChanged and replacements are the totals of the run, printed
+	// by Close.
+	filesChanged atomic.Int64
+	replacements atomic.Int64
+
+	// matcher is the Matcher of the search, so a replace selects exactly
+	// the lines and, for literal queries, the spans that a search reports.
+	matcher Matcher
+
+	// lengths skips the lines a search skips for their length.
+	lengths *lineLengths
+
+	// pairs are set with Pairs.
+	pairs []*pairReplacer
+
+	// patterns and patternMatchers are set with several patterns, to
+	// resolve and report overlapping matches of different patterns.
+	patterns        []string
+	patternMatchers []Matcher
+}
+
+// NewReplacer builds a Replacer for the query described by opts.
+func NewReplacer(opts *SearchOptions, ro ReplaceOptions) (*Replacer, error) {
+	if opts.Fuzzy > 0 || opts.Multiline {
+		return nil, fmt.Errorf("--replace does not support --fuzzy or --multiline")
+	}
+
+	matcher := opts.Matcher
+	if matcher == nil {
+		var err error
+		if matcher, err = NewMatcher(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Regex {
+		if err := checkTemplateRefs(ro.Replacement, opts.Re); err != nil {
+			return nil, err
+		}
+	}
+	switch ro.Conflict {
+	case "", ConflictFirst, ConflictError:
+	default:
+		return nil, fmt.Errorf("unknown --conflict %q (expected first or error)", ro.Conflict)
+	}
+	switch ro.PairOrder {
+	case "", PairsSequential:
+		if len(ro.Pairs) > 0 && ro.Conflict == ConflictError {
+			return nil, fmt.Errorf("--conflict error requires --replace-order independent; sequential pairs may rewrite each other's results")
+		}
+	case PairsIndependent:
+	default:
+		return nil, fmt.Errorf("unknown --replace-order %q (expected sequential or independent)", ro.PairOrder)
+	}
+
+	if ro.Output == nil {
+		ro.Output = os.Stdout
+	}
+	rp := &Replacer{
+		ReplaceOptions: ro,
+		re:             opts.Re,
+		literal:        !opts.Regex,
+		template:       parseReplaceTemplate(ro.Replacement),
+		matcher:        matcher,
+		lengths:        opts.LineLengths,
+	}
+	if len(opts.Patterns) > 1 {
+		rp.patterns = opts.Patterns
+		for _, pattern := range opts.Patterns {
+			m, err := newPatternMatcher(opts, pattern)
+			if err != nil {
+				return nil, err
+			}
+			rp.patternMatchers = append(rp.patternMatchers, m)
+		}
+	}
+	if len(ro.Pairs) > 0 {
+		var err error
+		if rp.pairs, err = newPairReplacers(opts, ro.Pairs); err != nil {
+			return nil, err
+		}
+	}
+	if ro.Interactive {
+		rp.confirm = newConfirmation(ro.Input, ro.Prompt)
+	}
+	if ro.ChangesJSON {
+		rp.changes = &changeReport{w: ro.Output}
+	}
+	if ro.UndoDir != "" && !ro.DryRun {
+		rp.journal = newUndoJournal(ro.UndoDir, ro.Fsync)
+	}
+	for _, root := range ro.Roots {
+		real, err := realPath(root)
+		if err != nil {
+			return nil, err
+		}
+		rp.realRoots = append(rp.realRoots, real)
+	}
+	return rp, nil
+}
+
+// ReplaceLine replaces at most n matches in line, or all of them when n is
+// zero, and returns the new line together with the number of replacements.
+// Matches are found left to right and never overlap, so a match that starts
+// inside a previous one is not counted.
+//
+// With a Scope, only the parts of line matched by it are rewritten, each on
+// its own: a match of the query that crosses the edge of a scope span is left
+// alone, and adjacent spans cannot clobber each other.
+func (rp *Replacer) ReplaceLine(line string, n int) (string, int) {
+	if rp.Scope == nil {
+		return rp.replaceIn(line, n)
+	}
+
+	spans := rp.Scope.FindAllStringIndex(line, -1)
+	if len(spans) == 0 {
+		return line, 0
+	}
+	// The limit applies left to right, so the spans are rewritten in that
+	// order first and spliced in from the right afterwards, which keeps
+	// the offsets of the spans not spliced yet valid.
+	replaced := make([]string, len(spans))
+	total := 0
+	for i, span := range spans {
+		limit := 0
+		if n > 0 {
+			limit = n - total
+			if limit == 0 {
+				spans, replaced = spans[:i], replaced[:i]
+				break
+			}
+		}
+		var count int
+		replaced[i], count = rp.replaceIn(line[span[0]:span[1]], limit)
+		total += count
+	}
+	for i := len(spans) - 1; i >= 0; i-- {
+		line = line[:spans[i][0]] + replaced[i] + line[spans[i][1]:]
+	}
+	return line, total
+}
+
+// replaceIn replaces at most n matches of the query in line, or all of them
+// when n is zero.
+func (rp *Replacer) replaceIn(line string, n int) (string, int) {
+	if n <= 0 {
+		n = -1
+	}
+	if rp.pairs != nil {
+		return rp.replacePairs(line, n)
+	}
+	matches := rp.find(line, n)
+	if len(matches) == 0 {
+		return line, 0
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		sb.WriteString(line[last:m[0]])
+		if rp.literal {
+			sb.WriteString(rp.Replacement)
+		} else {
+			sb.Write(rp.template.expand(nil, rp.re, line, m))
+		}
+		last = m[1]
+	}
+	sb.WriteString(line[last:])
+	return sb.String(), len(matches)
+}
+
+// find returns the submatch indexes of at most n matches in line, or of all
+// of them when n is negative. Lines the search would not report, e.g.
+// because of --not, have none. Regex replacements need the submatches for
+// $1 expansion and take them from re, which the search matches with too.
+func (rp *Replacer) find(line string, n int) [][]int {
+	spans := rp.matcher.Match([]byte(line))
+	if spans == nil {
+		return nil
+	}
+	if !rp.literal {
+		return rp.re.FindAllStringSubmatchIndex(line, n)
+	}
+	if rp.patternMatchers != nil {
+		// The alternation of regex patterns already prefers the leftmost
+		// match and then the first pattern; literal patterns are resolved
+		// the same way here.
+		spans = nil
+		end := 0
+		for _, m := range rp.occurrences(line) {
+			if m.Start >= end {
+				spans = append(spans, m.Span)
+				end = m.End
+			}
+		}
+	}
+	var matches [][]int
+	for _, sp := range spans {
+		if n >= 0 && len(matches) == n {
+			break
+		}
+		// An empty query matches every line but has nothing to replace.
+		if sp.Start < sp.End {
+			matches = append(matches, []int{sp.Start, sp.End})
+		}
+	}
+	return matches
+}
+
+// occurrence is a match of the pattern with the given index.
+type occurrence struct {
+	Span
+	pattern int
+}
+
+// occurrences returns the non-empty matches of every pattern in line,
+// ordered by start and then by pattern.
+func (rp *Replacer) occurrences(line string) []occurrence {
+	var all []occurrence
+	for i, m := range rp.patternMatchers {
+		for _, sp := range m.Match([]byte(line)) {
+			if sp.Start < sp.End {
+				all = append(all, occurrence{sp, i})
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		return all[i].pattern < all[j].pattern
+	})
+	return all
+}
+
+// conflict returns an error describing the first overlap of matches of
+// two different patterns in line, or nil. Matches that only touch, e.g.
+// "foo" and "bar" in "foobar", do not overlap.
+func (rp *Replacer) conflict(line string) error {
+	var last occurrence
+	for _, m := range rp.occurrences(line) {
+		// Matches of one pattern never overlap, so whatever m
+		// overlaps belongs to another pattern.
+		if m.Start < last.End {
+			return fmt.Errorf("matches of %q and %q overlap at column %d (--conflict error)", rp.patterns[last.pattern], rp.patterns[m.pattern], m.Start+1)
+		}
+		if m.End > last.End {
+			last = m
+		}
+	}
+	return nil
+}
+
+// target returns the file a replace in fileName writes to: its real path, so
+// a symlink is kept and the file it points to is updated. Paths that resolve
+// outside every search root, e.g. through a symlink in the tree, are refused
+// unless AllowOutside is set, so a replace never writes elsewhere on disk by
+// accident.
+func (rp *Replacer) target(fileName string) (string, error) {
+	real, err := realPath(fileName)
+	if err != nil {
+		return "", err
+	}
+	if rp.AllowOutside || len(rp.realRoots) == 0 {
+		return real, nil
+	}
+	for _, root := range rp.realRoots {
+		if real == root || strings.HasPrefix(real, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("refusing to modify %s: it resolves to %s, outside the search root (use --allow-outside to override)", fileName, real)
+}
+
+// realPath returns the absolute path of name with every symlink resolved.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// ReplaceFile applies the replacement to every line of fileName. The new
+// content is written to a temporary file next to the original and renamed
+// over it, so a failure never leaves a half-written file behind. In dry-run
+// mode the changes are only printed. In interactive mode only the confirmed
+// lines change, and quitting leaves the current file and every later one
+// untouched. A cancelled ctx abandons the file without writing it.
+func (rp *Replacer) ReplaceFile(ctx context.Context, fileName string) error {
+	if rp.more.Load() {
+		return nil
+	}
+	target, err := rp.target(fileName)
+	if err != nil {
+		return err
+	}
+
+	if rp.confirm != nil {
+		rp.confirm.mu.Lock()
+		defer rp.confirm.mu.Unlock()
+		if rp.confirm.stopped() {
+			return nil
+		}
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var out strings.Builder
+	var preview strings.Builder
+	var changes []jsonChange
+	var changed []changedLine
+	reader := bufio.NewReader(file)
+	lineNum, total := 0, 0
+	claimed := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			lineNum++
+			body, ending := splitLineEnding(line)
+
+			replaced, count, rerr := rp.replaceNext(body, total)
+			if rerr != nil {
+				return fmt.Errorf("line %d: %w", lineNum, rerr)
+			}
+			if count > 0 && !claimed {
+				if !rp.claimPreview() {
+					return nil
+				}
+				claimed = true
+			}
+			if count > 0 && rp.confirm != nil && !rp.confirm.confirm(fileName, lineNum, body, replaced) {
+				if rp.confirm.stopped() {
+					return nil
+				}
+				replaced, count = body, 0
+			}
+			if count > 0 {
+				total += count
+				if rp.Verify {
+					changed = append(changed, changedLine{num: lineNum, before: body})
+				}
+				if rp.changes != nil {
+					changes = append(changes, jsonChange{Line: lineNum, Before: body, After: replaced})
+				} else if rp.DryRun {
+					fmt.Fprintf(&preview, "%s:%d\n", displayPath(fileName), lineNum)
+					fmt.Fprintln(&preview, color.Red.Sprintf("- %s", body))
+					fmt.Fprintln(&preview, color.Green.Sprintf("+ %s", replaced))
+				}
+			}
+			out.WriteString(replaced)
+			out.WriteString(ending)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	if rp.counting {
+		rp.filesChanged.Add(1)
+		rp.replacements.Add(int64(total))
+		return nil
+	}
+	if rp.changes != nil {
+		rp.changes.add(fileName, total, changes)
+		rp.tally(total)
+		return nil
+	}
+	if rp.DryRun {
+		rp.tally(total)
+		// One write keeps the preview of a file together.
+		rp.printf("%s%s\n", preview.String(), color.Info.Sprintf("%s: %d replacement(s) would be made", displayPath(fileName), total))
+		return nil
+	}
+
+	if rp.BackupSuffix != "" {
+		if err := rp.backup(target); err != nil {
+			return err
+		}
+	}
+	if rp.journal != nil {
+		original, err := os.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		if err := rp.journal.record(target, original, out.String()); err != nil {
+			return fmt.Errorf("undo journal: %w", err)
+		}
+	}
+	var modTime time.Time
+	if rp.PreserveTimestamps {
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		modTime = info.ModTime()
+	}
+	if err := writeFileAtomic(target, out.String(), rp.Fsync); err != nil {
+		return err
+	}
+	if rp.PreserveTimestamps {
+		// The zero access time leaves it as the rewrite set it.
+		if err := os.Chtimes(target, time.Time{}, modTime); err != nil {
+			return err
+		}
+	}
+	rp.tally(total)
+	rp.printf("%s\n", color.Info.Sprintf("%s: %d replacement(s)", displayPath(fileName), total))
+	if rp.Verify {
+		rp.verify(fileName, target, out.String(), changed)
+	}
+	return nil
+}
+
+// replaceNext applies the replacement to the next line of a file, given
+// the total of replacements made in the file so far, honouring Limit and
+// Conflict.
+func (rp *Replacer) replaceNext(body string, total int) (string, int, error) {
+	if !rp.lengths.fits([]byte(body)) {
+		return body, 0, nil
+	}
+	n := 0
+	if rp.Limit > 0 {
+		n = rp.Limit
+		if !rp.PerLine {
+			n -= total
+		}
+		if n <= 0 {
+			return body, 0, nil
+		}
+	}
+	replaced, count := rp.ReplaceLine(body, n)
+	if count > 0 && rp.Conflict == ConflictError && rp.patternMatchers != nil {
+		if err := rp.conflict(body); err != nil {
+			return body, 0, err
+		}
+	}
+	return replaced, count, nil
+}
+
+// ReplaceStream copies r to w with the replacement applied, like sed. It
+// works line by line, so a stream of any length is never held in memory;
+// Limit counts the replacements of the whole stream.
+func (rp *Replacer) ReplaceStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	lineNum, total := 0, 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			lineNum++
+			body, ending := splitLineEnding(line)
+			replaced, count, rerr := rp.replaceNext(body, total)
+			if rerr != nil {
+				return fmt.Errorf("line %d: %w", lineNum, rerr)
+			}
+			total += count
+			if _, werr := io.WriteString(w, replaced+ending); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			if total > 0 {
+				rp.tally(total)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitLineEnding separates a line read with ReadString('\n') from its
+// terminator so that CRLF and LF endings survive a rewrite untouched.
+func splitLineEnding(line string) (string, string) {
+	if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], "\r\n"
+	}
+	if strings.HasSuffix(line, "\n") {
+		return line[:len(line)-1], "\n"
+	}
+	return line, ""
+}
+
+// claimPreview is called at the first change of a file and reports whether
+// the file may still be shown under PreviewLimit. Once a file is refused, the
+// files that follow are skipped without being read.
+func (rp *Replacer) claimPreview() bool {
+	if rp.PreviewLimit == 0 || (!rp.DryRun && !rp.Interactive) {
+		return true
+	}
+	if rp.previews.Add(1) <= int64(rp.PreviewLimit) {
+		return true
+	}
+	rp.more.Store(true)
+	return false
+}
+
+// printf writes to Output.
+func (rp *Replacer) printf(format string, args ...interface{}) {
+	rp.outMu.Lock()
+	defer rp.outMu.Unlock()
+	fmt.Fprintf(rp.Output, format, args...)
+}
+
+// tally counts a changed file with n replacements.
+func (rp *Replacer) tally(n int) {
+	rp.filesChanged.Add(1)
+	rp.replacements.Add(int64(n))
+	rp.Stats.replaced(n)
+}
+
+// Close prints the totals of the run, writes the --replace-dry-run-json
+// report, if any, and notes when --replace-preview-limit left files out. It
+// is called once the scan is over, also when it was cancelled.
+func (rp *Replacer) Close() error {
+	files, replacements := rp.filesChanged.Load(), rp.replacements.Load()
+	if rp.changes == nil {
+		verb := "made"
+		if rp.DryRun {
+			verb = "would be made"
+		}
+		rp.printf("%s\n", color.Info.Sprintf("%d replacement(s) %s in %d file(s)", replacements, verb, files))
+	}
+	if rp.journal != nil {
+		if err := rp.journal.Close(); err != nil {
+			return fmt.Errorf("undo journal: %w", err)
+		}
+		if rp.journal.used() {
+			rp.printf("%s\n", color.Info.Sprintf("Run findme replace-undo to restore the original files (journal in %s)", rp.journal.dir))
+		}
+	}
+	if rp.more.Load() {
+		note := fmt.Sprintf("More files would change; stopped after %d (--replace-preview-limit)", rp.PreviewLimit)
+		if rp.changes != nil {
+			// Keep the JSON document on stdout intact.
+			fmt.Fprintln(os.Stderr, note)
+		} else {
+			rp.printf("%s\n", color.Warn.Sprint(note))
+		}
+	}
+	if rp.changes == nil {
+		return nil
+	}
+	return rp.changes.write(files, replacements)
+}
+
+// backup copies fileName to fileName+BackupSuffix before it is rewritten.
+// The copy goes through a temporary file and a rename, so the backup is
+// either complete or absent and never a truncated file.
+func (rp *Replacer) backup(fileName string) error {
+	backupName := fileName + rp.BackupSuffix
+	if _, err := os.Lstat(backupName); err == nil && !rp.Force {
+		return fmt.Errorf("backup %s already exists (use --force to overwrite it)", backupName)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	original, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicAs(backupName, fileName, string(original), rp.Fsync)
+}
+
+// writeFileAtomic replaces the content of fileName by writing to a temporary
+// file in the same directory and renaming it over the original, keeping the
+// original permission bits and, where the user may set them, its owner and
+// group. A failure at any step leaves the original
+// untouched. With fsync, the temporary file is flushed before the rename and
+// the directory after it.
+func writeFileAtomic(fileName, content string, fsync bool) error {
+	return writeFileAtomicAs(fileName, fileName, content, fsync)
+}
+
+// createTemp and renameFile are the steps of writeFileAtomicAs that create
+// and replace files; tests stub them to fail.
+var (
+	createTemp = os.CreateTemp
+	renameFile = os.Rename
+)
+
+// writeFileAtomicAs writes content to fileName through a temporary file and
+// a rename, giving it the permission bits, owner and group of modeFrom.
+func writeFileAtomicAs(fileName, modeFrom, content string, fsync bool) error {
+	info, err := os.Stat(modeFrom)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := createTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".findme-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if fsync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	// The owner goes first: a chown by anyone but root clears the setuid
+	// and setgid bits that Chmod then restores.
+	chownLike(tmpName, info)
+	if err := os.Chmod(tmpName, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := renameFile(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if fsync {
+		return syncDir(filepath.Dir(fileName))
+	}
+	return nil
+}
+
+// syncDir flushes a directory so that a rename inside it is durable.
+// Windows cannot sync directories and makes renames durable on its own.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gookit/color"
+)
+
+// stub replaces *v with value for the rest of the test.
+func stub[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	old := *v
+	*v = value
+	t.Cleanup(func() { *v = old })
+}
+
+// checkFile fails unless the file at path holds want and its directory
+// has no temporary file left over.
+func checkFile(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s holds %q, want %q", path, got, want)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".findme-") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomicFailures(t *testing.T) {
+	const original = "keep\nthis\n"
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{"read-only directory", func(t *testing.T, dir string) {
+			if err := os.Chmod(dir, 0o555); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(dir, 0o755) })
+			if os.Geteuid() == 0 {
+				// Root writes to the directory regardless of its mode,
+				// so the refusal is simulated.
+				stub(t, &createTemp, func(string, string) (*os.File, error) {
+					return nil, &fs.PathError{Op: "open", Path: dir, Err: syscall.EACCES}
+				})
+			}
+		}},
+		{"failed rename", func(t *testing.T, dir string) {
+			stub(t, &renameFile, func(string, string) error { return errors.New("rename failed") })
+		}},
+		{"failed write", func(t *testing.T, dir string) {
+			// The temporary file is open for reading only, so writing
+			// its content fails.
+			stub(t, &createTemp, func(dir, pattern string) (*os.File, error) {
+				f, err := os.CreateTemp(dir, pattern)
+				if err != nil {
+					return nil, err
+				}
+				f.Close()
+				return os.Open(f.Name())
+			})
+		}},
+	}
+	for _, tt := range tests {
+		for _, fsync := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/fsync=%v", tt.name, fsync), func(t *testing.T) {
+				dir := t.TempDir()
+				path := filepath.Join(dir, "file.txt")
+				if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				tt.setup(t, dir)
+				if err := writeFileAtomic(path, "replaced\n", fsync); err == nil {
+					t.Fatal("writeFileAtomic succeeded")
+				}
+				checkFile(t, path, original)
+			})
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("old\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, "new\n", true); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "new\n")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+}
+
+// testReplacer returns the Replacer for query with ro, after edit has
+// adjusted the search flags. Colors are off for the test, and the output
+// goes to ro.Output or, when that is nil, is discarded.
+func testReplacer(t *testing.T, query string, ro ReplaceOptions, edit func(*SearchOptions)) *Replacer {
+	t.Helper()
+	stub(t, &color.Enable, false)
+	opts, _ := testOptions(t, query, edit)
+	if ro.Output == nil {
+		ro.Output = io.Discard
+	}
+	rp, err := NewReplacer(opts, ro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestReplacerOutput(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "foo\nbar\nfoo foo\n", "b.txt": "foo\n"})
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	tests := []struct {
+		name string
+		ro   ReplaceOptions
+		want string
+	}{
+		{"dry run", ReplaceOptions{Replacement: "baz", DryRun: true},
+			a + ":1\n- foo\n+ baz\n" + a + ":3\n- foo foo\n+ baz baz\n" + a + ": 3 replacement(s) would be made\n" +
+				b + ":1\n- foo\n+ baz\n" + b + ": 1 replacement(s) would be made\n" +
+				"4 replacement(s) would be made in 2 file(s)\n"},
+		{"preview limit", ReplaceOptions{Replacement: "baz", DryRun: true, PreviewLimit: 1},
+			a + ":1\n- foo\n+ baz\n" + a + ":3\n- foo foo\n+ baz baz\n" + a + ": 3 replacement(s) would be made\n" +
+				"3 replacement(s) would be made in 1 file(s)\n" +
+				"More files would change; stopped after 1 (--replace-preview-limit)\n"},
+		{"write", ReplaceOptions{Replacement: "baz"},
+			a + ": 3 replacement(s)\n" + b + ": 1 replacement(s)\n4 replacement(s) made in 2 file(s)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.ro.Output = &out
+			rp := testReplacer(t, "foo", tt.ro, nil)
+			for _, path := range []string{a, b} {
+				if err := rp.ReplaceFile(context.Background(), path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := rp.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceLineEndings(t *testing.T) {
+	tests := []struct {
+		name, query, text, want string
+		regex                   bool
+	}{
+		{"lf", "foo", "foo\nbar foo\n", "baz\nbar baz\n", false},
+		{"crlf", "foo", "foo\r\nbar foo\r\n", "baz\r\nbar baz\r\n", false},
+		{"mixed without final newline", "foo", "foo\r\nfoo\nfoo", "baz\r\nbaz\nbaz", false},
+		// The \r is not part of the line, so $ matches before it.
+		{"anchored crlf", "o$", "foo\r\nfoo x\r\n", "fobaz\r\nfoo x\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(writeTree(t, map[string]string{"a.txt": tt.text}), "a.txt")
+			rp := testReplacer(t, tt.query, ReplaceOptions{Replacement: "baz"}, func(o *SearchOptions) { o.Regex = tt.regex })
+			if err := rp.ReplaceFile(context.Background(), path); err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, path, tt.want)
+		})
+	}
+}
+
+func TestReplaceLineFlags(t *testing.T) {
+	tests := []struct {
+		name, query, replacement string
+		edit                     func(*SearchOptions)
+		line, want               string
+		count                    int
+	}{
+		{"literal", "foo", "X", nil, "foo food", "X Xd", 2},
+		{"whole word", "foo", "X", func(o *SearchOptions) { o.WholeWord = true }, "foo food (foo)", "X food (X)", 2},
+		{"ignore case", "foo", "X", func(o *SearchOptions) { o.CaseInsensitive = true }, "Foo FOO", "X X", 2},
+		{"ignore case whole word", "foo", "X", func(o *SearchOptions) { o.CaseInsensitive, o.WholeWord = true, true }, "FOO foOd", "X foOd", 1},
+		{"identifier words", "foo", "X", func(o *SearchOptions) {
+			o.WholeWord = true
+			o.WordChars, _ = NewWordChars(WordBoundaryIdentifier, "")
+		}, "foo-bar foo_x", "X-bar foo_x", 1},
+		{"regex groups", `f(o+)`, "<$1>", func(o *SearchOptions) { o.Regex = true }, "fo foo", "<o> <oo>", 2},
+		{"regex ignore case", `fo+`, "X", func(o *SearchOptions) { o.Regex, o.CaseInsensitive = true, true }, "FOO fOo", "X X", 2},
+		{"not", "foo", "X", func(o *SearchOptions) { o.Not = []string{"bar"} }, "foo bar", "foo bar", 0},
+		{"and missing", "foo", "X", func(o *SearchOptions) { o.And = []string{"bar"} }, "foo baz", "foo baz", 0},
+		{"and", "foo", "X", func(o *SearchOptions) { o.And = []string{"bar"} }, "foo bar", "X bar", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := testReplacer(t, tt.query, ReplaceOptions{Replacement: tt.replacement}, tt.edit)
+			got, count := rp.ReplaceLine(tt.line, 0)
+			if got != tt.want || count != tt.count {
+				t.Errorf("ReplaceLine(%q) = %q, %d; want %q, %d", tt.line, got, count, tt.want, tt.count)
+			}
+		})
+	}
+}
+
+func TestReplaceConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		line     string
+		first    string
+		overlap  bool
+	}{
+		{"adjacent", []string{"foo", "bar"}, "foobar", "XX", false},
+		{"apart", []string{"foo", "bar"}, "foo bar", "X X", false},
+		{"nested", []string{"foobar", "oba"}, "foobar", "X", true},
+		{"nested listed first", []string{"oba", "foobar"}, "foobar", "X", true},
+		{"same start", []string{"foo", "foobar"}, "foobar", "Xbar", true},
+		{"crossing", []string{"foob", "obar"}, "foobar", "Xar", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit := func(o *SearchOptions) { o.Query, o.Patterns = tt.patterns[0], tt.patterns }
+			rp := testReplacer(t, "", ReplaceOptions{Replacement: "X"}, edit)
+			if got, _ := rp.ReplaceLine(tt.line, 0); got != tt.first {
+				t.Errorf("--conflict first: ReplaceLine(%q) = %q, want %q", tt.line, got, tt.first)
+			}
+
+			rp = testReplacer(t, "", ReplaceOptions{Replacement: "X", Conflict: ConflictError}, edit)
+			got, _, err := rp.replaceNext(tt.line, 0)
+			if tt.overlap {
+				if err == nil {
+					t.Errorf("--conflict error: %q replaced with %q, want an error", tt.line, got)
+				}
+			} else if err != nil || got != tt.first {
+				t.Errorf("--conflict error: replaceNext(%q) = %q, %v; want %q", tt.line, got, err, tt.first)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Values of --replace-order.
+const (
+	PairsSequential  = "sequential"
+	PairsIndependent = "independent"
+)
+
+// ReplacePair is one --replace-pair: matches of Old are replaced with New.
+type ReplacePair struct {
+	Old, New string
+}
+
+// ParseReplacePair splits an OLD=NEW argument at the first '=' not escaped
+// by a backslash. \= stands for a literal '=' in OLD; NEW is taken as is
+// and may be empty to delete the matches.
+func ParseReplacePair(arg string) (ReplacePair, error) {
+	var old strings.Builder
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == '=':
+			old.WriteByte('=')
+			i++
+		case arg[i] == '=':
+			if old.Len() == 0 {
+				return ReplacePair{}, fmt.Errorf("invalid --replace-pair %q: the text to replace is empty", arg)
+			}
+			return ReplacePair{Old: old.String(), New: arg[i+1:]}, nil
+		default:
+			old.WriteByte(arg[i])
+		}
+	}
+	return ReplacePair{}, fmt.Errorf("invalid --replace-pair %q (expected OLD=NEW; write \\= for an '=' in OLD)", arg)
+}
+
+// pairReplacer is a ReplacePair prepared for the query options of the
+// search: Old is matched like a pattern of --patterns-file would be.
+type pairReplacer struct {
+	replacement string
+	template    replaceTemplate
+
+	// re is set in regex mode, matcher otherwise.
+	re      *regexp.Regexp
+	matcher Matcher
+}
+
+func newPairReplacers(opts *SearchOptions, pairs []ReplacePair) ([]*pairReplacer, error) {
+	var prs []*pairReplacer
+	for _, pair := range pairs {
+		pr := &pairReplacer{replacement: pair.New, template: parseReplaceTemplate(pair.New)}
+		if opts.Regex {
+			pattern := pair.Old
+			if opts.CaseInsensitive {
+				pattern = "(?i)" + pattern
+			}
+			re, err := compileRegexp(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %v", pair.Old, err)
+			}
+			if err := checkTemplateRefs(pair.New, re); err != nil {
+				return nil, fmt.Errorf("--replace-pair %s=%s: %w", pair.Old, pair.New, err)
+			}
+			pr.re = re
+		} else {
+			m, err := newPatternMatcher(opts, pair.Old)
+			if err != nil {
+				return nil, err
+			}
+			pr.matcher = m
+		}
+		prs = append(prs, pr)
+	}
+	return prs, nil
+}
+
+// find returns the non-empty matches of the pair in line, left to right, as
+// submatch indexes in regex mode and start and end otherwise.
+func (pr *pairReplacer) find(line string) [][]int {
+	if pr.re != nil {
+		var matches [][]int
+		for _, m := range pr.re.FindAllStringSubmatchIndex(line, -1) {
+			if m[0] < m[1] {
+				matches = append(matches, m)
+			}
+		}
+		return matches
+	}
+	var matches [][]int
+	for _, sp := range pr.matcher.Match([]byte(line)) {
+		if sp.Start < sp.End {
+			matches = append(matches, []int{sp.Start, sp.End})
+		}
+	}
+	return matches
+}
+
+// expand appends the replacement of match m in line to sb.
+func (pr *pairReplacer) expand(sb *strings.Builder, line string, m []int) {
+	if pr.re == nil {
+		sb.WriteString(pr.replacement)
+		return
+	}
+	sb.Write(pr.template.expand(nil, pr.re, line, m))
+}
+
+// pairMatch is a match of the pair with the given index.
+type pairMatch struct {
+	indexes []int
+	pair    int
+}
+
+// replacePairs replaces at most n matches of the pairs in line, or all of
+// them when n is negative. Lines the search would not report have none.
+//
+// Sequentially, each pair rewrites the result of the pairs before it, so
+// a=b then b=c turns a into c, and the earlier pairs use up the limit first.
+// The count is of pair applications: that a, rewritten by both pairs,
+// counts twice.
+// Independently, every pair is matched against the original line and each
+// part of it is rewritten at most once, so a=b and b=a swap the two. Where
+// matches of two pairs overlap, the leftmost one is kept and, among those
+// starting at the same byte, the one of the pair given first, the same rule
+// that resolves several patterns.
+func (rp *Replacer) replacePairs(line string, n int) (string, int) {
+	if rp.matcher.Match([]byte(line)) == nil {
+		return line, 0
+	}
+	if rp.PairOrder == PairsIndependent {
+		var all []pairMatch
+		for i, pr := range rp.pairs {
+			for _, m := range pr.find(line) {
+				all = append(all, pairMatch{m, i})
+			}
+		}
+		sort.SliceStable(all, func(i, j int) bool {
+			if all[i].indexes[0] != all[j].indexes[0] {
+				return all[i].indexes[0] < all[j].indexes[0]
+			}
+			return all[i].pair < all[j].pair
+		})
+		var sb strings.Builder
+		last, count := 0, 0
+		for _, m := range all {
+			if n >= 0 && count == n {
+				break
+			}
+			if m.indexes[0] < last {
+				continue
+			}
+			sb.WriteString(line[last:m.indexes[0]])
+			rp.pairs[m.pair].expand(&sb, line, m.indexes)
+			last = m.indexes[1]
+			count++
+		}
+		if count == 0 {
+			return line, 0
+		}
+		sb.WriteString(line[last:])
+		return sb.String(), count
+	}
+
+	total := 0
+	for _, pr := range rp.pairs {
+		if n >= 0 && total == n {
+			break
+		}
+		matches := pr.find(line)
+		if n >= 0 && len(matches) > n-total {
+			matches = matches[:n-total]
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		var sb strings.Builder
+		last := 0
+		for _, m := range matches {
+			sb.WriteString(line[last:m[0]])
+			pr.expand(&sb, line, m)
+			last = m[1]
+		}
+		sb.WriteString(line[last:])
+		line = sb.String()
+		total += len(matches)
+	}
+	return line, total
+}
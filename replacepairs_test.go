@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseReplacePair(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want ReplacePair
+		err  bool
+	}{
+		{"old=new", ReplacePair{"old", "new"}, false},
+		{"a=b=c", ReplacePair{"a", "b=c"}, false},
+		{`a\=b=c`, ReplacePair{"a=b", "c"}, false},
+		{`a\b=c`, ReplacePair{`a\b`, "c"}, false},
+		{"del=", ReplacePair{"del", ""}, false},
+		{"=new", ReplacePair{}, true},
+		{"nothing", ReplacePair{}, true},
+		{`a\=b`, ReplacePair{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReplacePair(tt.arg)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseReplacePair(%q) = %v, %v; want %v, error %v", tt.arg, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestReplacePairs(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		pairs []ReplacePair
+		regex bool
+		limit int
+		line  string
+		want  string
+		n     int
+	}{
+		// Sequentially, a is rewritten by both pairs and counts twice.
+		{"chain", PairsSequential, []ReplacePair{{"a", "b"}, {"b", "c"}}, false, -1, "a b", "c c", 3},
+		{"chain independent", PairsIndependent, []ReplacePair{{"a", "b"}, {"b", "c"}}, false, -1, "a b", "b c", 2},
+		{"swap", PairsIndependent, []ReplacePair{{"a", "b"}, {"b", "a"}}, false, -1, "ab ba", "ba ab", 4},
+		{"swap sequential", PairsSequential, []ReplacePair{{"a", "b"}, {"b", "a"}}, false, -1, "ab", "aa", 3},
+		{"overlap first pair wins", PairsIndependent, []ReplacePair{{"foo", "1"}, {"foobar", "2"}}, false, -1, "foobar", "1bar", 1},
+		{"overlap leftmost wins", PairsIndependent, []ReplacePair{{"oba", "1"}, {"foo", "2"}}, false, -1, "foobar", "2bar", 1},
+		// The earlier pairs use up the limit first.
+		{"limit sequential", PairsSequential, []ReplacePair{{"a", "b"}, {"b", "c"}}, false, 2, "a a b", "b b b", 2},
+		{"limit independent", PairsIndependent, []ReplacePair{{"a", "x"}, {"b", "y"}}, false, 2, "a b a b", "x y a b", 2},
+		{"regex groups", PairsSequential, []ReplacePair{{`(\w+)@old`, "${1}@new"}}, true, -1, "me@old you@old", "me@new you@new", 2},
+		{"delete", PairsSequential, []ReplacePair{{"x", ""}}, false, -1, "axbx", "ab", 2},
+		{"no match", PairsSequential, []ReplacePair{{"zz", "y"}}, false, -1, "abc", "abc", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patterns []string
+			for _, p := range tt.pairs {
+				patterns = append(patterns, p.Old)
+			}
+			query := patterns[0]
+			if tt.regex && len(patterns) > 1 {
+				query = regexAlternation(patterns)
+			}
+			rp := testReplacer(t, query, ReplaceOptions{Pairs: tt.pairs, PairOrder: tt.order}, func(o *SearchOptions) {
+				o.Patterns = patterns
+				o.Regex = tt.regex
+			})
+			got, n := rp.replacePairs(tt.line, tt.limit)
+			if got != tt.want || n != tt.n {
+				t.Errorf("replacePairs(%q) = %q, %d; want %q, %d", tt.line, got, n, tt.want, tt.n)
+			}
+		})
+	}
+}
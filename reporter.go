@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Output formats accepted by --format.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+)
+
+// SchemaVersion is the version of the JSON and JSON Lines output schema. It is
+// bumped whenever a field is renamed or removed.
+const SchemaVersion = 1
+
+// Match is a single matching line. Offset is the byte position of the start
+// of the line within the file; Start and End are the byte offsets of the first
+// match within Text. Spans holds every match within Text from left to right,
+// or with --only-matching only the one reported; it is empty for the lines
+// of --invert-match.
+type Match struct {
+	Path   string
+	Line   int
+	Offset int64
+	Text   string
+	Start  int
+	End    int
+	Spans  []Span
+
+	// Scope is the line opening the function or section around the
+	// match, with --context-scope.
+	Scope *ScopeLine
+}
+
+// Reporter renders matches. Workers report concurrently, so implementations
+// must be safe for concurrent use. Close is called once the scan stops, even
+// when it was cancelled or failed, and must leave the output well formed.
+type Reporter interface {
+	Match(m Match)
+	// Count reports the number of matching lines of a file in the
+	// count-only modes.
+	Count(path string, n int)
+	Close() error
+}
+
+// NewReporter returns the Reporter for the given --format value.
+func NewReporter(format string, w io.Writer, opts *SearchOptions) (Reporter, error) {
+	switch format {
+	case "", FormatText:
+		colors := opts.Colors
+		if colors == nil {
+			colors, _ = ParseColors(nil)
+		}
+		return &TextReporter{w: w, opts: opts, colors: colors}, nil
+	case FormatJSON:
+		return &JSONReporter{w: w, opts: opts}, nil
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &JSONLReporter{enc: enc, opts: opts}, nil
+	case FormatCSV:
+		return NewCSVReporter(w, opts, ','), nil
+	case FormatTSV:
+		return NewCSVReporter(w, opts, '\t'), nil
+	case FormatEvents:
+		return NewEventsReporter(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected text, json, jsonl, csv or tsv)", format)
+	}
+}
+
+// TextReporter prints matches as path[:line][:column][:offset]:text with
+// every match of the line highlighted in the styles of colors. A line cut to
+// --max-columns highlights only the match it is cut around.
+type TextReporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	opts   *SearchOptions
+	colors *ColorScheme
+	tally  countTally
+
+	// heading is the file of the last heading printed.
+	heading string
+
+	// last is the file and line of the last match printed, and scope the
+	// last scope line printed, so a scope is shown once for the matches
+	// inside it and not at all when it was printed as a match.
+	last, scope struct {
+		path string
+		line int
+	}
+
+	// preview counts the results of the current file shown and held back
+	// under --preview-lines.
+	preview struct {
+		path           string
+		shown, skipped int
+	}
+}
+
+func (r *TextReporter) Match(m Match) {
+	opts, colors := r.opts, r.colors
+	var sb strings.Builder
+	if opts.WithFilename && !opts.Heading {
+		sb.WriteString(colors.Path.Sprint(reportPath(opts, m.Path)))
+		sb.WriteByte(':')
+	}
+	if opts.LineNumber || opts.Column {
+		sb.WriteString(colors.Line.Sprint(m.Line))
+		sb.WriteByte(':')
+	}
+	if opts.Column {
+		sb.WriteString(colors.Column.Sprint(matchColumn(opts, m)))
+		sb.WriteByte(':')
+	}
+	if opts.ByteOffset {
+		sb.WriteString(colors.Column.Sprint(m.Offset + int64(m.Start)))
+		sb.WriteByte(':')
+	}
+	line, start, end := truncateLine(m.Text, m.Start, m.End, opts.MaxColumns, opts.MaxColumnsPreview)
+	if opts.OnlyMatching {
+		sb.WriteString(colors.Match.Sprint(m.Text[m.Start:m.End]))
+	} else if line == m.Text && len(m.Spans) > 0 {
+		// An untruncated line has every match highlighted.
+		last := 0
+		for _, sp := range m.Spans {
+			sb.WriteString(line[last:sp.Start])
+			sb.WriteString(colors.Match.Sprint(line[sp.Start:sp.End]))
+			last = sp.End
+		}
+		sb.WriteString(line[last:])
+	} else if start >= 0 && end <= len(line) && start < end {
+		sb.WriteString(line[:start])
+		sb.WriteString(colors.Match.Sprint(line[start:end]))
+		sb.WriteString(line[end:])
+	} else {
+		sb.WriteString(line)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if opts.PreviewLines > 0 {
+		if m.Path != r.preview.path {
+			r.endPreview()
+			r.preview.path = m.Path
+		}
+		if r.preview.shown == opts.PreviewLines {
+			r.preview.skipped++
+			return
+		}
+		r.preview.shown++
+	}
+	if opts.WithFilename && opts.Heading && m.Path != r.heading {
+		// Files are separated by an empty line.
+		if r.heading != "" {
+			fmt.Fprintln(r.w)
+		}
+		fmt.Fprintln(r.w, colors.Path.Sprint(reportPath(opts, m.Path)))
+		r.heading = m.Path
+	}
+	if s := m.Scope; s != nil && (r.scope.path != m.Path || r.scope.line != s.Line) {
+		r.scope.path, r.scope.line = m.Path, s.Line
+		if r.last.path != m.Path || r.last.line != s.Line {
+			fmt.Fprintln(r.w, r.scopeLine(m.Path, s))
+		}
+	}
+	r.last.path, r.last.line = m.Path, m.Line
+	fmt.Fprintln(r.w, sb.String())
+}
+
+// scopeLine formats the scope line of a match like a context line of grep,
+// with '-' instead of ':' after the file name and line number.
+func (r *TextReporter) scopeLine(path string, s *ScopeLine) string {
+	opts, colors := r.opts, r.colors
+	var sb strings.Builder
+	if opts.WithFilename && !opts.Heading {
+		sb.WriteString(colors.Path.Sprint(reportPath(opts, path)))
+		sb.WriteByte('-')
+	}
+	if opts.LineNumber || opts.Column {
+		sb.WriteString(colors.Line.Sprint(s.Line))
+		sb.WriteByte('-')
+	}
+	line, _, _ := truncateLine(s.Text, 0, 0, opts.MaxColumns, opts.MaxColumnsPreview)
+	sb.WriteString(line)
+	return sb.String()
+}
+
+func (r *TextReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.tally.add(r.opts, n) {
+		return
+	}
+
+	var line string
+	switch {
+	case r.opts.CountMode != CountLines:
+		line = r.colors.Path.Sprint(reportPath(r.opts, path))
+	case r.opts.WithFilename:
+		line = r.colors.Path.Sprint(reportPath(r.opts, path)) + ":" + fmt.Sprint(n)
+	default:
+		line = fmt.Sprint(n)
+	}
+	fmt.Fprintln(r.w, line)
+}
+
+// endPreview notes how many results of the current file --preview-lines left
+// out. The results of a file arrive together under a heading, so a file is
+// over when the next one starts or the scan ends.
+func (r *TextReporter) endPreview() {
+	if r.preview.skipped > 0 {
+		fmt.Fprintf(r.w, "... (%d more)\n", r.preview.skipped)
+	}
+	r.preview.shown, r.preview.skipped = 0, 0
+}
+
+func (r *TextReporter) Close() error {
+	r.mu.Lock()
+	r.endPreview()
+	r.mu.Unlock()
+	if r.opts.CountMode == CountTotal {
+		_, err := fmt.Fprintln(r.w, r.tally.total)
+		return err
+	}
+	return nil
+}
+
+// countTally implements the counting modes on top of the per-file counts so
+// that every format lists the same files. It also keeps the run total for
+// --count-total. Callers serialize access.
+type countTally struct {
+	total int64
+}
+
+// add records the count of one file and reports whether the file is listed.
+// --count lists files without matches only with --include-zero.
+func (t *countTally) add(opts *SearchOptions, n int) bool {
+	switch opts.CountMode {
+	case CountLines:
+		return n > 0 || opts.IncludeZero
+	case CountFilesWith:
+		return n > 0
+	case CountFilesWithout:
+		return n == 0
+	case CountTotal:
+		t.total += int64(n)
+	}
+	return false
+}
+
+// jsonMatch is the JSON representation of a Match shared by the json and
+// jsonl formats.
+// Columns are 1-based; EndColumn is the column just after the first match,
+// so EndColumn - Column is its length, and Spans lists the columns of every
+// match in the same way.
+type jsonMatch struct {
+	Version   int        `json:"version"`
+	Path      string     `json:"path"`
+	Line      int        `json:"line"`
+	Column    int        `json:"column"`
+	EndColumn int        `json:"end_column"`
+	Offset    int64      `json:"offset"`
+	Text      string     `json:"text"`
+	Spans     []jsonSpan `json:"spans"`
+	Scope     *jsonScope `json:"scope,omitempty"`
+}
+
+// jsonScope is the JSON representation of the ScopeLine of a match.
+type jsonScope struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// jsonSpan is the JSON representation of a Span, in columns.
+type jsonSpan struct {
+	Column    int `json:"column"`
+	EndColumn int `json:"end_column"`
+}
+
+func newJSONMatch(opts *SearchOptions, m Match) jsonMatch {
+	spans := make([]jsonSpan, len(m.Spans))
+	for i, sp := range m.Spans {
+		spans[i] = jsonSpan{Column: textColumn(opts, m.Text, sp.Start), EndColumn: textColumn(opts, m.Text, sp.End)}
+	}
+	jm := jsonMatch{
+		Version:   SchemaVersion,
+		Path:      reportPath(opts, m.Path),
+		Line:      m.Line,
+		Column:    matchColumn(opts, m),
+		EndColumn: textColumn(opts, m.Text, m.End),
+		Offset:    m.Offset + int64(m.Start),
+		Text:      m.Text,
+		Spans:     spans,
+	}
+	if m.Scope != nil {
+		jm.Scope = &jsonScope{Line: m.Scope.Line, Text: m.Scope.Text}
+	}
+	return jm
+}
+
+// jsonCount is the JSON representation of a per-file count.
+type jsonCount struct {
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+	Count   int    `json:"count"`
+}
+
+// jsonFile is the JSON representation of a file listed by
+// --files-with-matches or --files-without-match.
+type jsonFile struct {
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+}
+
+// jsonTotal is the JSON representation of --count-total.
+type jsonTotal struct {
+	Version int   `json:"version"`
+	Total   int64 `json:"total"`
+}
+
+// newJSONCount returns the object reported for a listed file in the count
+// modes. Listing files stops at the first match, so they carry no count.
+func newJSONCount(opts *SearchOptions, path string, n int) interface{} {
+	if opts.CountMode != CountLines {
+		return jsonFile{Version: SchemaVersion, Path: reportPath(opts, path)}
+	}
+	return jsonCount{Version: SchemaVersion, Path: reportPath(opts, path), Count: n}
+}
+
+// JSONLReporter streams one JSON object per match, one per line.
+type JSONLReporter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	opts  *SearchOptions
+	tally countTally
+}
+
+func (r *JSONLReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(newJSONMatch(r.opts, m))
+}
+
+func (r *JSONLReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tally.add(r.opts, n) {
+		r.enc.Encode(newJSONCount(r.opts, path, n))
+	}
+}
+
+func (r *JSONLReporter) Close() error {
+	if r.opts.CountMode == CountTotal {
+		return r.enc.Encode(jsonTotal{Version: SchemaVersion, Total: r.tally.total})
+	}
+	return nil
+}
+
+// JSONReporter buffers every match and writes them as a single JSON array on
+// Close, so the output is a valid document even when the scan stops early.
+type JSONReporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	opts    *SearchOptions
+	matches []interface{}
+	tally   countTally
+}
+
+func (r *JSONReporter) Match(m Match) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.matches = append(r.matches, newJSONMatch(r.opts, m))
+}
+
+func (r *JSONReporter) Count(path string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tally.add(r.opts, n) {
+		r.matches = append(r.matches, newJSONCount(r.opts, path, n))
+	}
+}
+
+func (r *JSONReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(r.w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if r.opts.CountMode == CountTotal {
+		return enc.Encode(jsonTotal{Version: SchemaVersion, Total: r.tally.total})
+	}
+
+	matches := r.matches
+	if matches == nil {
+		matches = []interface{}{}
+	}
+	return enc.Encode(matches)
+}
+
+// matchColumn returns the 1-based column of the first match of m in the unit
+// selected by --column-unit. Start is a byte offset, so rune columns count
+// the characters before it.
+func matchColumn(opts *SearchOptions, m Match) int {
+	return textColumn(opts, m.Text, m.Start)
+}
+
+// textColumn returns the 1-based column of the byte offset i of text.
+func textColumn(opts *SearchOptions, text string, i int) int {
+	if opts.ColumnUnit == ColumnRune {
+		return utf8.RuneCountInString(text[:clamp(i, 0, len(text))]) + 1
+	}
+	return i + 1
+}
+
+// displayPath normalizes a path for output. Walk results join the directory
+// given on the command line with native separators, which can leave a mix of
+// '/' and '\' on Windows; cleaning yields one consistent separator.
+func displayPath(path string) string {
+	return filepath.Clean(path)
+}
+
+// reportPath is displayPath for results, made absolute for --absolute-path
+// and written with --path-separator instead of the native separator when
+// one is given. Standard input has no path of its own and keeps its name.
+func reportPath(opts *SearchOptions, path string) string {
+	path = displayPath(path)
+	if opts.AbsolutePath && path != stdinPath {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	if opts.PathSeparator != "" {
+		path = strings.ReplaceAll(path, string(filepath.Separator), opts.PathSeparator)
+	}
+	return path
+}
+
+// truncateLine shortens line to at most max runes, marking each cut with an
+// ellipsis. The head of the line is kept unless preview is set, in which case
+// the window is centred on the match [start, end). It returns the shortened
+// line with the match offsets adjusted to it, or -1 offsets when the match
+// fell outside the window.
+func truncateLine(line string, start, end, max int, preview bool) (string, int, int) {
+	runes := utf8.RuneCountInString(line)
+	if max <= 0 || runes <= max {
+		return line, start, end
+	}
+
+	from := 0
+	if preview && start >= 0 {
+		matchStart := utf8.RuneCountInString(line[:start])
+		matchLen := utf8.RuneCountInString(line[start:end])
+		from = matchStart - (max-matchLen)/2
+		if from > runes-max {
+			from = runes - max
+		}
+		if from < 0 {
+			from = 0
+		}
+	}
+
+	byteFrom := runeOffset(line, from)
+	byteTo := runeOffset(line, from+max)
+
+	var prefix, suffix string
+	if byteFrom > 0 {
+		prefix = ellipsis
+	}
+	if byteTo < len(line) {
+		suffix = ellipsis
+	}
+	out := prefix + line[byteFrom:byteTo] + suffix
+
+	if start < 0 {
+		return out, -1, -1
+	}
+	start = clamp(start, byteFrom, byteTo) - byteFrom + len(prefix)
+	end = clamp(end, byteFrom, byteTo) - byteFrom + len(prefix)
+	if start >= end {
+		return out, -1, -1
+	}
+	return out, start, end
+}
+
+const ellipsis = "…"
+
+// runeOffset returns the byte offset of the n-th rune of s, or len(s) when s
+// has fewer runes.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
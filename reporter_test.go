@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir changes the working directory to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func TestReportPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.Join(wd, "dir", "a.txt")
+	tests := []struct {
+		path     string
+		absolute bool
+		sep      string
+		want     string
+	}{
+		{"dir/./a.txt", false, "", filepath.Join("dir", "a.txt")},
+		{"dir/a.txt", true, "", abs},
+		{abs, true, "", abs},
+		{"dir/a.txt", false, "/", "dir/a.txt"},
+		{"dir/a.txt", true, "/", filepath.ToSlash(abs)},
+		{stdinPath, true, "", stdinPath},
+	}
+	for _, tt := range tests {
+		opts := &SearchOptions{AbsolutePath: tt.absolute, PathSeparator: tt.sep}
+		if got := reportPath(opts, tt.path); got != tt.want {
+			t.Errorf("reportPath(%q) with absolute %v and separator %q = %q, want %q", tt.path, tt.absolute, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestAbsolutePathSources(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":    "needle\n",
+		"list.txt": "a.txt\n",
+	})
+	f, err := os.Create(filepath.Join(root, "b.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("inner.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("needle\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	chdir(t, root)
+	// The temporary directory can be behind a symlink, as on macOS.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"dir", []string{"-d", "a.txt", "-H"}, filepath.Join(wd, "a.txt") + ":needle\n"},
+		{"files-from", []string{"--files-from", "list.txt"}, filepath.Join(wd, "a.txt") + ":needle\n"},
+		{"archive", []string{"-d", "b.zip", "-H", "--search-zip"}, filepath.Join(wd, "b.zip") + zipEntrySeparator + "inner.txt:needle\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSearch(t, append([]string{"-q", "needle", "--absolute-path"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
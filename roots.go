@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ExpandRoots turns the --dir argument into the paths to search. A value
+// containing glob metacharacters is expanded with doublestar, so
+// 'src/**/handlers' or '*.go' work without shell globbing. A plain path is
+// returned as is.
+func ExpandRoots(dir string) ([]string, error) {
+	if !hasGlobMeta(dir) {
+		return []string{dir}, nil
+	}
+	if !doublestar.ValidatePattern(filepath.ToSlash(dir)) {
+		return nil, fmt.Errorf("invalid glob %q", dir)
+	}
+	roots, err := doublestar.FilepathGlob(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no files or directories match %q", dir)
+	}
+	return roots, nil
+}
+
+// checkRoots makes sure every root exists and can be opened before the
+// walk starts, so a mistyped --dir fails the run instead of printing a
+// walk error and exiting 0. Errors on files found during the walk stay
+// warnings.
+func checkRoots(roots []string) error {
+	for _, root := range roots {
+		f, err := os.Open(root)
+		if err != nil {
+			return &exitError{code: exitTrouble, err: fmt.Errorf("cannot search %s: %w", root, err)}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[{")
+}
+
+// absoluteRoots makes every root absolute. Walk results are joined to their
+// root, so this is enough for every reported path to be absolute.
+func absoluteRoots(roots []string) ([]string, error) {
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		path, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		abs[i] = path
+	}
+	return abs, nil
+}
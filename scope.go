@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultScopePatterns match the lines that open a function, type or section
+// in the files with the given extension, for --context-scope. They are a
+// heuristic: the last such line before a match is taken as its scope, with
+// no attempt to find where the scope ends.
+var defaultScopePatterns = map[string]string{
+	".go":    `^\s*func\b|^type\s+\w+\s+(struct|interface)\b`,
+	".py":    `^\s*(async\s+)?(def|class)\s`,
+	".rb":    `^\s*(def|class|module)\s`,
+	".js":    `^\s*(export\s+)?(default\s+)?(async\s+)?(function\b|class\s)`,
+	".jsx":   `^\s*(export\s+)?(default\s+)?(async\s+)?(function\b|class\s)`,
+	".mjs":   `^\s*(export\s+)?(default\s+)?(async\s+)?(function\b|class\s)`,
+	".ts":    `^\s*(export\s+)?(default\s+)?(abstract\s+)?(async\s+)?(function\b|class\s|interface\s)`,
+	".tsx":   `^\s*(export\s+)?(default\s+)?(abstract\s+)?(async\s+)?(function\b|class\s|interface\s)`,
+	".java":  `^\s*((public|protected|private|static|final|abstract|synchronized)\s+)+[^=;]*\(|^\s*((public|protected|private|static|final|abstract)\s+)*(class|interface|enum|record)\s`,
+	".cs":    `^\s*((public|protected|private|internal|static|virtual|override|async|sealed|abstract)\s+)+[^=;]*\(|^\s*((public|protected|private|internal|static|sealed|abstract|partial)\s+)*(class|interface|enum|struct|record)\s`,
+	".kt":    `^\s*((public|protected|private|internal|open|override|suspend|data|abstract)\s+)*(fun|class|interface|object)\s`,
+	".swift": `^\s*((public|private|internal|fileprivate|open|static|override|final)\s+)*(func|class|struct|enum|protocol|extension)\s`,
+	".rs":    `^\s*(pub(\([^)]*\))?\s+)?(async\s+)?(unsafe\s+)?(fn|impl|struct|enum|trait|mod)\b`,
+	".c":     `^[A-Za-z_][\w\s\*]*\([^;]*$|^(struct|union|enum)\s+\w+\s*\{`,
+	".h":     `^[A-Za-z_][\w\s\*]*\([^;]*$|^(struct|union|enum)\s+\w+\s*\{`,
+	".cc":    `^[A-Za-z_][\w\s\*&:<>,~]*\([^;]*$|^\s*(class|struct|namespace)\s+\w+`,
+	".cpp":   `^[A-Za-z_][\w\s\*&:<>,~]*\([^;]*$|^\s*(class|struct|namespace)\s+\w+`,
+	".hpp":   `^[A-Za-z_][\w\s\*&:<>,~]*\([^;]*$|^\s*(class|struct|namespace)\s+\w+`,
+	".php":   `^\s*((abstract|final|public|protected|private|static)\s+)*(function|class|interface|trait)\s`,
+	".sh":    `^\s*(function\s+\w+|\w+\s*\(\)\s*\{?\s*$)`,
+	".bash":  `^\s*(function\s+\w+|\w+\s*\(\)\s*\{?\s*$)`,
+	".md":    `^#{1,6}\s`,
+}
+
+// ScopePatterns are the scope patterns of --context-scope by extension.
+type ScopePatterns map[string]*regexp.Regexp
+
+// ParseScopePatterns compiles the default scope patterns, overridden or
+// extended by --scope-pattern values of the form EXT=REGEX, e.g.
+// 'lua=^\s*(local\s+)?function\b'. An empty REGEX turns scopes off for EXT.
+func ParseScopePatterns(specs []string) (ScopePatterns, error) {
+	sources := make(map[string]string, len(defaultScopePatterns))
+	for ext, pattern := range defaultScopePatterns {
+		sources[ext] = pattern
+	}
+	for _, spec := range specs {
+		ext, pattern, ok := strings.Cut(spec, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !ok || ext == "" || ext == "." {
+			return nil, fmt.Errorf("invalid --scope-pattern %q (expected EXT=REGEX, e.g. 'lua=^function')", spec)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		sources[ext] = pattern
+	}
+
+	patterns := make(ScopePatterns, len(sources))
+	for ext, source := range sources {
+		if source == "" {
+			continue
+		}
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --scope-pattern for %s: %v", ext, err)
+		}
+		patterns[ext] = re
+	}
+	return patterns, nil
+}
+
+// forFile returns the scope pattern for the extension of path, or nil when
+// its kind of file has none. A nil ScopePatterns has none for any file.
+func (p ScopePatterns) forFile(path string) *regexp.Regexp {
+	return p[strings.ToLower(filepath.Ext(path))]
+}
+
+// ScopeLine is the line that opens the scope of a match.
+type ScopeLine struct {
+	Line int
+	Text string
+}
+
+// scopeTracker follows the scope while the lines of one file go by in order.
+// A nil *scopeTracker tracks nothing.
+type scopeTracker struct {
+	re      *regexp.Regexp
+	current *ScopeLine
+}
+
+func newScopeTracker(re *regexp.Regexp) *scopeTracker {
+	if re == nil {
+		return nil
+	}
+	return &scopeTracker{re: re}
+}
+
+// next returns the scope line in effect at line lineNum, the last scope
+// opened before it, and then notes whether the line opens one itself.
+func (t *scopeTracker) next(lineNum int, line []byte) *ScopeLine {
+	if t == nil {
+		return nil
+	}
+	scope := t.current
+	if t.re.Match(line) {
+		t.current = &ScopeLine{Line: lineNum, Text: string(line)}
+	}
+	return scope
+}
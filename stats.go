@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// TypeStats is the number and total size of the files with one extension.
+type TypeStats struct {
+	Extension string `json:"extension"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// noExtension labels files without an extension in the stats output.
+const noExtension = "(none)"
+
+func newStatsCommand() *cli.Command {
+	var dirPath, format string
+	var isRecursive, ignoreGlobal bool
+	var maxDepth int
+	var ignoreFiles, include, exclude, excludeDir, includeFrom, excludeFrom cli.StringSlice
+
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "Count files and bytes per extension in a directory",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "dir",
+				Aliases:     []string{"d"},
+				Usage:       "Directory to inspect; globs are expanded",
+				Destination: &dirPath,
+				Required:    true,
+			},
+			&cli.BoolFlag{
+				Name:        "recursive",
+				Aliases:     []string{"R"},
+				Usage:       "Include subdirectories",
+				Destination: &isRecursive,
+			},
+			&cli.IntFlag{
+				Name:        "max-depth",
+				Usage:       "Descend at most N directory levels; 0 counts only the files directly inside the directory",
+				Destination: &maxDepth,
+			},
+			&cli.StringSliceFlag{
+				Name:        "include",
+				Usage:       "Only count files whose name matches the glob (repeatable)",
+				Destination: &include,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude",
+				Usage:       "Skip files whose name matches the glob (repeatable)",
+				Destination: &exclude,
+			},
+			&cli.StringSliceFlag{
+				Name:        "include-from",
+				Usage:       "Also only search files matching the globs in this file, one per line (repeatable)",
+				Destination: &includeFrom,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude-from",
+				Usage:       "Also skip files matching the globs in this file, one per line (repeatable)",
+				Destination: &excludeFrom,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude-dir",
+				Usage:       "Do not descend into directories whose name matches the glob (repeatable)",
+				Destination: &excludeDir,
+			},
+			&cli.StringSliceFlag{
+				Name:        "ignore-file",
+				Usage:       "Skip paths matching the gitignore-style patterns in this file (repeatable)",
+				Destination: &ignoreFiles,
+			},
+			&cli.BoolFlag{
+				Name:        "respect-ignore-global",
+				Usage:       "Also skip paths matching the user's global gitignore (core.excludesFile or ~/.config/git/ignore)",
+				Destination: &ignoreGlobal,
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Output format: text or json",
+				Value:       FormatText,
+				Destination: &format,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if format != FormatText && format != FormatJSON {
+				return fmt.Errorf("unknown format %q (expected text or json)", format)
+			}
+
+			depth, err := searchDepth(isRecursive, maxDepth, c.IsSet("max-depth"))
+			if err != nil {
+				return err
+			}
+			opts := &SearchOptions{MaxDepth: depth}
+			roots, err := ExpandRoots(dirPath)
+			if err != nil {
+				return err
+			}
+
+			ignore, err := loadIgnoreFiles(roots, ignoreFiles.Value(), ignoreGlobal)
+			if err != nil {
+				return err
+			}
+			opts.Ignore = ignore
+
+			filter, err := newPathFilterFrom(include.Value(), exclude.Value(), excludeDir.Value(), includeFrom.Value(), excludeFrom.Value())
+			if err != nil {
+				return err
+			}
+			opts.Filter = filter
+
+			stats, err := collectTypeStats(c.Context, roots, opts)
+			if err != nil {
+				return err
+			}
+			if format == FormatJSON {
+				return writeTypeStatsJSON(os.Stdout, stats)
+			}
+			return writeTypeStats(os.Stdout, stats)
+		},
+	}
+}
+
+// collectTypeStats walks the roots with the same walker and filters as a
+// search and returns the per-extension totals, the most frequent extension
+// first.
+func collectTypeStats(ctx context.Context, roots []string, opts *SearchOptions) ([]TypeStats, error) {
+	fileChan := make(chan string)
+	go func() {
+		defer close(fileChan)
+		for _, root := range roots {
+			listFiles(ctx, root, opts, fileChan)
+		}
+	}()
+
+	byExt := make(map[string]*TypeStats)
+	for path := range fileChan {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == "" {
+			ext = noExtension
+		}
+		st, ok := byExt[ext]
+		if !ok {
+			st = &TypeStats{Extension: ext}
+			byExt[ext] = st
+		}
+		st.Files++
+		st.Bytes += info.Size()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make([]TypeStats, 0, len(byExt))
+	for _, st := range byExt {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Files != stats[j].Files {
+			return stats[i].Files > stats[j].Files
+		}
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Extension < stats[j].Extension
+	})
+	return stats, nil
+}
+
+func writeTypeStats(w io.Writer, stats []TypeStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "EXTENSION\tFILES\tBYTES\t")
+	var files int
+	var bytes int64
+	for _, st := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", st.Extension, st.Files, st.Bytes)
+		files += st.Files
+		bytes += st.Bytes
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t\n", files, bytes)
+	return tw.Flush()
+}
+
+func writeTypeStatsJSON(w io.Writer, stats []TypeStats) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Version int         `json:"version"`
+		Types   []TypeStats `json:"types"`
+	}{SchemaVersion, stats})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+)
+
+// stdinPath names standard input in results, e.g. (stdin):3 with --count.
+const stdinPath = "(stdin)"
+
+// stdinIsPiped reports whether standard input is a pipe or a file rather
+// than a terminal, so a search without --dir reads it instead of waiting for
+// the user to type.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// searchStdin searches standard input as one file named stdinPath. It has no
+// file boundaries, so every counting mode sees a single file.
+func searchStdin(ctx context.Context, opts *SearchOptions) error {
+	opts.Stats.walked()
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(opts, stdinPath, r)
+		}
+	}()
+	return Process(ctx, bufio.NewReader(os.Stdin), stdinPath, opts)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RunStats are the totals of a search run for --summary-json. The walker,
+// readers and workers update them concurrently. The methods do nothing on a
+// nil *RunStats, so the stages need not check whether a summary was asked
+// for.
+type RunStats struct {
+	start         time.Time
+	filesWalked   atomic.Int64
+	filesSearched atomic.Int64
+	filesSkipped  atomic.Int64
+	matches       atomic.Int64
+	bytes         atomic.Int64
+	maxBytes      atomic.Bool
+
+	// replace is set for a --replace run, whose totals are reported too.
+	replace      *jsonReplaceSummary
+	filesChanged atomic.Int64
+	replacements atomic.Int64
+}
+
+// NewRunStats starts the clock of a run.
+func NewRunStats() *RunStats {
+	return &RunStats{start: time.Now()}
+}
+
+// walked counts a file handed from the walk to the readers.
+func (s *RunStats) walked() {
+	if s != nil {
+		s.filesWalked.Add(1)
+	}
+}
+
+// skipped counts a file that could not be searched to the end.
+func (s *RunStats) skipped() {
+	if s != nil {
+		s.filesSkipped.Add(1)
+	}
+}
+
+// searched counts a file searched to the end with its size and matches.
+func (s *RunStats) searched(bytes int64, matches int) {
+	if s != nil {
+		s.filesSearched.Add(1)
+		s.bytes.Add(bytes)
+		s.matches.Add(int64(matches))
+	}
+}
+
+// replacing makes the summary report the totals of a --replace run; dryRun
+// tells consumers that nothing was written.
+func (s *RunStats) replacing(dryRun bool) {
+	if s != nil {
+		s.replace = &jsonReplaceSummary{DryRun: dryRun}
+	}
+}
+
+// replaced counts a file changed by --replace with n replacements.
+func (s *RunStats) replaced(n int) {
+	if s != nil {
+		s.filesChanged.Add(1)
+		s.replacements.Add(int64(n))
+	}
+}
+
+// limitReached records that --max-bytes ended the run.
+func (s *RunStats) limitReached() {
+	if s != nil {
+		s.maxBytes.Store(true)
+	}
+}
+
+// Exit reasons of the summary.
+const (
+	ExitCompleted   = "completed"
+	ExitMaxBytes    = "max_bytes"
+	ExitInterrupted = "interrupted"
+	ExitError       = "error"
+)
+
+// jsonSummary is the --summary-json object.
+type jsonSummary struct {
+	Version       int    `json:"version"`
+	FilesWalked   int64  `json:"files_walked"`
+	FilesSearched int64  `json:"files_searched"`
+	FilesSkipped  int64  `json:"files_skipped"`
+	Matches       int64  `json:"matches"`
+	Bytes         int64  `json:"bytes"`
+	DurationMS    int64  `json:"duration_ms"`
+	ExitReason    string `json:"exit_reason"`
+	Error         string `json:"error,omitempty"`
+
+	Replace *jsonReplaceSummary `json:"replace,omitempty"`
+}
+
+// jsonReplaceSummary is the totals of a --replace run. With dry_run they are
+// the changes that would have been made.
+type jsonReplaceSummary struct {
+	FilesChanged int64 `json:"files_changed"`
+	Replacements int64 `json:"replacements"`
+	DryRun       bool  `json:"dry_run"`
+}
+
+// writeSummary writes the totals as a single JSON object. err is the error
+// the run ends with, if any; the object is written whatever it is.
+func (s *RunStats) writeSummary(w io.Writer, err error) error {
+	summary := jsonSummary{
+		Version:       SchemaVersion,
+		FilesWalked:   s.filesWalked.Load(),
+		FilesSearched: s.filesSearched.Load(),
+		FilesSkipped:  s.filesSkipped.Load(),
+		Matches:       s.matches.Load(),
+		Bytes:         s.bytes.Load(),
+		DurationMS:    time.Since(s.start).Milliseconds(),
+		ExitReason:    ExitCompleted,
+	}
+	if s.replace != nil {
+		replace := *s.replace
+		replace.FilesChanged = s.filesChanged.Load()
+		replace.Replacements = s.replacements.Load()
+		summary.Replace = &replace
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		summary.ExitReason = ExitInterrupted
+	case err != nil:
+		summary.ExitReason = ExitError
+		summary.Error = err.Error()
+	case s.maxBytes.Load():
+		summary.ExitReason = ExitMaxBytes
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(summary)
+}
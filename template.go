@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// caseMode is a case conversion applied to part of an expanded replacement.
+type caseMode int
+
+const (
+	caseKeep caseMode = iota
+	caseUpper
+	caseLower
+)
+
+// templatePart is a run of a replacement template with a single case
+// conversion. first, when set, converts only the first character and takes
+// precedence over mode for it.
+type templatePart struct {
+	text  string
+	mode  caseMode
+	first caseMode
+}
+
+// replaceTemplate is a regex replacement that may hold the sed and Perl case
+// directives: \U and \L convert the text that follows to upper or lower case
+// until \E or the end, and \u and \l convert only the next character. The
+// directives apply after $1-style expansion, so \U$1 uppercases a capture
+// group. \\ stands for a single backslash; any other backslash is kept.
+type replaceTemplate []templatePart
+
+func parseReplaceTemplate(s string) replaceTemplate {
+	var t replaceTemplate
+	var sb strings.Builder
+	mode, first := caseKeep, caseKeep
+	// flush ends the current part. A pending \u or \l carries over to the
+	// next part when no text followed it yet.
+	flush := func() {
+		if sb.Len() == 0 {
+			return
+		}
+		t = append(t, templatePart{text: sb.String(), mode: mode, first: first})
+		sb.Reset()
+		first = caseKeep
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case 'U':
+			flush()
+			mode = caseUpper
+		case 'L':
+			flush()
+			mode = caseLower
+		case 'E':
+			flush()
+			mode = caseKeep
+		case 'u':
+			flush()
+			first = caseUpper
+		case 'l':
+			flush()
+			first = caseLower
+		case '\\':
+			sb.WriteByte('\\')
+		default:
+			sb.WriteByte('\\')
+			continue
+		}
+		i++
+	}
+	flush()
+	return t
+}
+
+// expand appends the replacement for the submatch indexes match of src to dst.
+func (t replaceTemplate) expand(dst []byte, re *regexp.Regexp, src string, match []int) []byte {
+	for _, p := range t {
+		text := string(re.ExpandString(nil, p.text, src, match))
+		text = applyCase(text, p.mode)
+		if p.first != caseKeep && text != "" {
+			r, size := utf8.DecodeRuneInString(text)
+			text = applyCase(string(r), p.first) + text[size:]
+		}
+		dst = append(dst, text...)
+	}
+	return dst
+}
+
+func applyCase(s string, mode caseMode) string {
+	switch mode {
+	case caseUpper:
+		return strings.ToUpper(s)
+	case caseLower:
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+// checkTemplateRefs returns an error for the first $name, ${name}, $1 or ${1}
+// in repl that has no group in re. Expansion would silently drop such a
+// reference, which usually means a typo in a group name.
+func checkTemplateRefs(repl string, re *regexp.Regexp) error {
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '$' || i+1 == len(repl) {
+			continue
+		}
+		rest := repl[i+1:]
+		if rest[0] == '$' {
+			i++
+			continue
+		}
+		name := rest
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				continue
+			}
+			name = rest[1:end]
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool {
+				return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			if end >= 0 {
+				name = rest[:end]
+			}
+		}
+		if name == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				return fmt.Errorf("replacement refers to group %d, but the pattern has %d", n, re.NumSubexp())
+			}
+			continue
+		}
+		if re.SubexpIndex(name) < 0 {
+			if unicode.IsDigit(rune(name[0])) {
+				return fmt.Errorf("replacement refers to group %q, which the pattern does not define; write ${%s} to separate a group number from the text after it", name, name[:strings.IndexFunc(name, func(r rune) bool { return !unicode.IsDigit(r) })])
+			}
+			return fmt.Errorf("replacement refers to group %q, which the pattern does not define with (?P<%s>...)", name, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCheckTemplateRefs(t *testing.T) {
+	re := regexp.MustCompile(`(?P<word>\w+)-(\d+)`)
+	tests := []struct {
+		repl string
+		want string // a part of the error, or "" for none
+	}{
+		{"plain text", ""},
+		{"$1 $2 ${1} ${2}", ""},
+		{"$0", ""},
+		{"$word ${word}x", ""},
+		{"costs $$3", ""},
+		{"ends with $", ""},
+		{"${unterminated", ""},
+		{"$3", "group 3, but the pattern has 2"},
+		{"${3}", "group 3, but the pattern has 2"},
+		{"$wrod", `group "wrod", which the pattern does not define with (?P<wrod>...)`},
+		{"${wrod}", `group "wrod"`},
+		{"$1x", "write ${1} to separate"},
+		{"$$ $3", "group 3"},
+	}
+	for _, tt := range tests {
+		err := checkTemplateRefs(tt.repl, re)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("checkTemplateRefs(%q) = %v, want nil", tt.repl, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("checkTemplateRefs(%q) = %v, want an error with %q", tt.repl, err, tt.want)
+		}
+	}
+}
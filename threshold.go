@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultConfirmThreshold is the number of files a replace may change
+// before --confirm-threshold asks first.
+const defaultConfirmThreshold = 50
+
+// previewReplace is the preview pass of --confirm-threshold. It runs the scan
+// of opts once with the replacer only counting the files and replacements it
+// would make, like a dry run that prints nothing, and returns the totals.
+// The pass has its own visited set, and its errors are left for the real
+// pass to report. A --files-from list is read into memory so both
+// passes see it.
+func previewReplace(ctx context.Context, roots []string, opts *SearchOptions, visited *visitedFiles) (files, replacements int64, err error) {
+	probe := *opts
+	probe.Stats = nil
+	probe.Ordered = nil
+	probe.Errors = &fileErrors{quiet: true}
+	probe.Visited = visited
+	if opts.FileList != nil {
+		list, err := io.ReadAll(opts.FileList)
+		if err != nil {
+			return 0, 0, fmt.Errorf("reading --files-from: %w", err)
+		}
+		opts.FileList = bytes.NewReader(list)
+		probe.FileList = bytes.NewReader(list)
+	}
+
+	rp := opts.Replacer
+	rp.counting = true
+	err = parallelListAndRead(ctx, roots, &probe)
+	rp.counting = false
+	return rp.filesChanged.Swap(0), rp.replacements.Swap(0), err
+}
+
+// confirmReplace lets a replace that would change files files, with the
+// given number of replacements, go ahead when that is within threshold.
+// Over it, the user is asked on the terminal. Without a terminal to ask
+// on, the run stops, and --yes is needed to write the changes.
+func confirmReplace(files, replacements int64, threshold int) error {
+	if files <= int64(threshold) {
+		return nil
+	}
+	summary := fmt.Sprintf("the replace would change %d file(s) with %d replacement(s), more than --confirm-threshold %d", files, replacements, threshold)
+	if stdinIsPiped() {
+		return fmt.Errorf("%s; nothing was written (use --yes to write the changes or --dry-run to review them)", summary)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s.\nWrite the changes [y/N]? ", summary)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("replace cancelled; nothing was written")
+}
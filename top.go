@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// TopReporter keeps the per-file counts of --count and, on Close, passes on
+// only the n files with the most matching lines, most first. Ties are broken
+// by path so the output is the same from run to run.
+type TopReporter struct {
+	mu     sync.Mutex
+	inner  Reporter
+	n      int
+	counts []fileTally
+}
+
+type fileTally struct {
+	path  string
+	count int
+}
+
+// NewTopReporter wraps inner so that only the top n counts reach it.
+func NewTopReporter(inner Reporter, n int) *TopReporter {
+	return &TopReporter{inner: inner, n: n}
+}
+
+func (r *TopReporter) Match(m Match) {
+	r.inner.Match(m)
+}
+
+func (r *TopReporter) Count(path string, n int) {
+	if n == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts = append(r.counts, fileTally{path: path, count: n})
+}
+
+func (r *TopReporter) Close() error {
+	r.mu.Lock()
+	counts := r.counts
+	r.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].path < counts[j].path
+	})
+	if len(counts) > r.n {
+		counts = counts[:r.n]
+	}
+	for _, c := range counts {
+		r.inner.Count(c.path, c.count)
+	}
+	return r.inner.Close()
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gookit/color"
+	"github.com/urfave/cli/v2"
+)
+
+// undoJournalFile is the journal inside the undo directory; the originals
+// are kept next to it in undoFilesDir.
+const (
+	undoJournalFile = "journal.jsonl"
+	undoFilesDir    = "files"
+)
+
+// undoEntry is one line of the journal: a file rewritten by --replace, where
+// its original content was saved, and the hashes of the content before and
+// after, which tell replace-undo whether the file was touched since.
+type undoEntry struct {
+	Version        int    `json:"version"`
+	Path           string `json:"path"`
+	Backup         string `json:"backup"`
+	OriginalSHA256 string `json:"original_sha256"`
+	ReplacedSHA256 string `json:"replaced_sha256"`
+}
+
+// undoJournal records the files a replace run rewrites so replace-undo can
+// restore them. Each original is saved and its entry appended before the
+// file is written, so a run that is cancelled or crashes half-way leaves a
+// journal of exactly the files it may have changed. The journal of the
+// previous run is dropped when the first file of a new run changes.
+type undoJournal struct {
+	dir   string
+	fsync bool
+
+	once    sync.Once
+	openErr error
+
+	mu   sync.Mutex
+	file *os.File
+	n    int
+}
+
+// defaultUndoDir is where the journal lives unless --undo-dir is given.
+func defaultUndoDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "findme", "undo"), nil
+}
+
+func newUndoJournal(dir string, fsync bool) *undoJournal {
+	return &undoJournal{dir: dir, fsync: fsync}
+}
+
+// open starts the journal of this run, replacing that of the last one.
+func (j *undoJournal) open() error {
+	j.once.Do(func() {
+		if err := removeUndoJournal(j.dir); err != nil {
+			j.openErr = err
+			return
+		}
+		if err := os.MkdirAll(filepath.Join(j.dir, undoFilesDir), 0o700); err != nil {
+			j.openErr = err
+			return
+		}
+		j.file, j.openErr = os.OpenFile(filepath.Join(j.dir, undoJournalFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	})
+	return j.openErr
+}
+
+// record saves original, the content of target before the rewrite, and
+// journals it. It must succeed before target is written.
+func (j *undoJournal) record(target string, original []byte, replaced string) error {
+	if err := j.open(); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.n++
+	backup := filepath.Join(undoFilesDir, fmt.Sprintf("%06d", j.n))
+	j.mu.Unlock()
+
+	// The copy keeps the permission bits of target, which replace-undo
+	// gives back to a restored file.
+	if err := writeFileAtomicAs(filepath.Join(j.dir, backup), target, string(original), j.fsync); err != nil {
+		return err
+	}
+	line, err := json.Marshal(undoEntry{
+		Version:        SchemaVersion,
+		Path:           abs,
+		Backup:         filepath.ToSlash(backup),
+		OriginalSHA256: sha256Hex(original),
+		ReplacedSHA256: sha256Hex([]byte(replaced)),
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// A single write per entry; a crash can at worst leave a torn last
+	// line, which readUndoJournal ignores.
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if j.fsync {
+		return j.file.Sync()
+	}
+	return nil
+}
+
+// used reports whether the run journaled any file.
+func (j *undoJournal) used() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.n > 0
+}
+
+func (j *undoJournal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// removeUndoJournal removes the journal in dir and the originals saved with
+// it. The directory itself is given by the user with --undo-dir and may hold
+// other files, so those and the directory are left alone.
+func removeUndoJournal(dir string) error {
+	if err := os.Remove(filepath.Join(dir, undoJournalFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(dir, undoFilesDir))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readUndoJournal returns the entries of the journal in dir, oldest first.
+func readUndoJournal(dir string) ([]undoEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, undoJournalFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no replace to undo in %s", dir)
+		}
+		return nil, err
+	}
+	var entries []undoEntry
+	lines := bytes.Split(data, []byte{'\n'})
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var e undoEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if i == len(lines)-1 {
+				// A last line without its newline was torn by a crash
+				// while it was written, before its file was touched.
+				break
+			}
+			return nil, fmt.Errorf("corrupt undo journal %s: %v", filepath.Join(dir, undoJournalFile), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func newReplaceUndoCommand() *cli.Command {
+	var undoDir, colorWhen string
+	var dryRun, force bool
+
+	return &cli.Command{
+		Name:  "replace-undo",
+		Usage: "Restore the files changed by the last --replace run",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "undo-dir",
+				Usage:       "Directory of the undo journal, as given to --replace (default: findme/undo in the user cache directory)",
+				Destination: &undoDir,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Show which files would be restored without writing any",
+				Destination: &dryRun,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				Usage:       "Also restore files that were changed again after the replace",
+				Destination: &force,
+			},
+			&cli.StringFlag{
+				Name:        "color",
+				Value:       ColorAuto,
+				Usage:       "When to color the output: auto colors it only on a terminal, always also in pipes and files, never not at all",
+				Destination: &colorWhen,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := setColor(colorWhen, isTerminal(os.Stdout)); err != nil {
+				return err
+			}
+			if undoDir == "" {
+				dir, err := defaultUndoDir()
+				if err != nil {
+					return fmt.Errorf("no undo directory: %v (use --undo-dir)", err)
+				}
+				undoDir = dir
+			}
+			entries, err := readUndoJournal(undoDir)
+			if err != nil {
+				return err
+			}
+			return undoReplace(undoDir, entries, dryRun, force)
+		},
+	}
+}
+
+// undoReplace restores the journaled files, newest first, so a file changed
+// twice in the run ends up with its first original. Files that no longer
+// hold the replaced content were changed since and are left alone unless
+// force is set. The journal is removed once every file is back.
+func undoReplace(dir string, entries []undoEntry, dryRun, force bool) error {
+	verb := "Restored"
+	if dryRun {
+		verb = "Would restore"
+	}
+	kept := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		current, err := os.ReadFile(e.Path)
+		switch {
+		case err == nil && sha256Hex(current) == e.OriginalSHA256:
+			// Not written yet when the run stopped, or restored already.
+			continue
+		case err == nil && sha256Hex(current) == e.ReplacedSHA256, force:
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", e.Path, err)
+			kept++
+			continue
+		default:
+			fmt.Fprintf(os.Stderr, "Skipping %s: it was changed or removed after the replace (use --force to restore it anyway)\n", e.Path)
+			kept++
+			continue
+		}
+
+		backup := filepath.Join(dir, filepath.FromSlash(e.Backup))
+		if dryRun {
+			fmt.Println(color.Info.Sprintf("%s %s", verb, e.Path))
+			continue
+		}
+		original, err := os.ReadFile(backup)
+		if err == nil && sha256Hex(original) != e.OriginalSHA256 {
+			err = fmt.Errorf("the saved copy %s is damaged", backup)
+		}
+		if err == nil {
+			err = writeFileAtomicAs(e.Path, backup, string(original), false)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s: %v\n", e.Path, err)
+			kept++
+			continue
+		}
+		fmt.Println(color.Info.Sprintf("%s %s", verb, e.Path))
+	}
+	if kept > 0 {
+		return fmt.Errorf("%d file(s) were not restored; the journal in %s is kept", kept, dir)
+	}
+	if dryRun {
+		return nil
+	}
+	return removeUndoJournal(dir)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gookit/color"
+)
+
+func TestUndoDirKeepsOtherFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":           "foo\n",
+		"undo/notes.txt":  "mine\n",
+		"undo/files/keep": "stale\n",
+	})
+	path, undoDir := filepath.Join(root, "a.txt"), filepath.Join(root, "undo")
+	notes := filepath.Join(undoDir, "notes.txt")
+
+	for _, run := range []struct{ query, replacement string }{{"foo", "bar"}, {"bar", "baz"}} {
+		rp := testReplacer(t, run.query, ReplaceOptions{Replacement: run.replacement, UndoDir: undoDir}, nil)
+		if err := rp.ReplaceFile(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+		if err := rp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, notes, "mine\n")
+	}
+	checkFile(t, path, "baz\n")
+	// Each run replaces the journal of the last one, here with the first
+	// run's copy of the file.
+	if _, err := os.Stat(filepath.Join(undoDir, undoFilesDir, "keep")); !os.IsNotExist(err) {
+		t.Errorf("a saved copy of an older journal survived: %v", err)
+	}
+
+	entries, err := readUndoJournal(undoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := undoReplace(undoDir, entries, false, false); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "bar\n")
+	checkFile(t, notes, "mine\n")
+	for _, name := range []string{undoJournalFile, undoFilesDir} {
+		if _, err := os.Stat(filepath.Join(undoDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left in the undo directory: %v", name, err)
+		}
+	}
+}
+
+func TestUndoColorFlag(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "foo\n"})
+	undoDir := filepath.Join(root, "undo")
+	rp := testReplacer(t, "foo", ReplaceOptions{Replacement: "bar", UndoDir: undoDir}, nil)
+	if err := rp.ReplaceFile(context.Background(), filepath.Join(root, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args  []string
+		color bool
+	}{
+		// The output of the tests is a file, not a terminal, so auto turns
+		// off the colors a color TERM would enable.
+		{nil, false},
+		{[]string{"--color", "never"}, false},
+		{[]string{"--color", "always"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			stub(t, &color.Enable, true)
+			args := append([]string{"replace-undo", "--undo-dir", undoDir, "--dry-run"}, tt.args...)
+			stdout, _, err := runCommand(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(stdout, "\x1b["); got != tt.color {
+				t.Errorf("colored = %v, want %v: %q", got, tt.color, stdout)
+			}
+			if !strings.Contains(stdout, "Would restore") {
+				t.Errorf("output %q lacks the listing", stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// uniqueSetLimit caps the number of distinct lines --unique remembers. Past
+// it, new lines are printed without being remembered, so duplicates of them
+// may show up again; lines seen before the cap are still suppressed.
+const uniqueSetLimit = 1 << 20
+
+// UniqueReporter drops matches whose printed text was already reported: the
+// whole line, or the matched text with --only-matching. Lines are keyed by
+// their 64-bit murmur3 hash instead of being stored, which keeps memory at a
+// few dozen bytes per distinct line.
+type UniqueReporter struct {
+	mu      sync.Mutex
+	inner   Reporter
+	opts    *SearchOptions
+	perFile bool
+	seen    map[uint64]struct{}
+	full    bool
+}
+
+// NewUniqueReporter wraps inner. With perFile, a line is only a duplicate of
+// the same line in the same file.
+func NewUniqueReporter(inner Reporter, opts *SearchOptions, perFile bool) *UniqueReporter {
+	return &UniqueReporter{
+		inner:   inner,
+		opts:    opts,
+		perFile: perFile,
+		seen:    make(map[uint64]struct{}),
+	}
+}
+
+func (r *UniqueReporter) Match(m Match) {
+	text := m.Text
+	if r.opts.OnlyMatching {
+		text = m.Text[m.Start:m.End]
+	}
+	h := murmur3.New64()
+	if r.perFile {
+		h.Write([]byte(m.Path))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(text))
+	key := h.Sum64()
+
+	r.mu.Lock()
+	_, dup := r.seen[key]
+	if !dup {
+		if len(r.seen) < uniqueSetLimit {
+			r.seen[key] = struct{}{}
+		} else if !r.full {
+			r.full = true
+			fmt.Fprintf(os.Stderr, "Warning: more than %d distinct lines, --unique may print duplicates from now on\n", uniqueSetLimit)
+		}
+	}
+	r.mu.Unlock()
+
+	if !dup {
+		r.inner.Match(m)
+	}
+}
+
+func (r *UniqueReporter) Count(path string, n int) {
+	r.inner.Count(path, n)
+}
+
+func (r *UniqueReporter) Begin(path string) {
+	if events, ok := r.inner.(FileEvents); ok {
+		events.Begin(path)
+	}
+}
+
+func (r *UniqueReporter) End(path string, matches int) {
+	if events, ok := r.inner.(FileEvents); ok {
+		events.End(path, matches)
+	}
+}
+
+func (r *UniqueReporter) Close() error {
+	return r.inner.Close()
+}
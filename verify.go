@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// changedLine is a line a replace rewrote, with its text before the change.
+type changedLine struct {
+	num    int
+	before string
+}
+
+// verify re-reads target after a rewrite and warns when it does not hold
+// want, the content written, or when the search still matches a changed
+// line. A match inside the text the replacement inserted is intended, as
+// with --replace 'foo()' for the query foo, and is not reported. With
+// Limit or Scope the replace leaves matches alone on purpose, so only the
+// content is checked.
+func (rp *Replacer) verify(fileName, target, want string, changed []changedLine) {
+	got, err := os.ReadFile(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot verify %s: %v (--verify-replace)\n", displayPath(fileName), err)
+		return
+	}
+	if string(got) != want {
+		fmt.Fprintf(os.Stderr, "Warning: %s does not hold the replaced content; it may have been changed meanwhile (--verify-replace)\n", displayPath(fileName))
+		return
+	}
+	if rp.Limit > 0 || rp.Scope != nil {
+		return
+	}
+
+	lines := strings.SplitAfter(want, "\n")
+	for _, c := range changed {
+		line, _ := splitLineEnding(lines[c.num-1])
+		inserted := rp.insertions(c.before)
+		for _, sp := range rp.matcher.Match([]byte(line)) {
+			if text := line[sp.Start:sp.End]; text != "" && !containsAny(inserted, text) {
+				fmt.Fprintf(os.Stderr, "Warning: %s:%d still matches after the replacement: %q (--verify-replace)\n", displayPath(fileName), c.num, text)
+				break
+			}
+		}
+	}
+}
+
+// insertions returns the texts the replacement inserts into line, one per
+// match; a literal replacement is always the same text.
+func (rp *Replacer) insertions(line string) []string {
+	if rp.literal {
+		return []string{rp.Replacement}
+	}
+	var texts []string
+	for _, m := range rp.find(line, -1) {
+		texts = append(texts, string(rp.template.expand(nil, rp.re, line, m)))
+	}
+	return texts
+}
+
+func containsAny(texts []string, s string) bool {
+	for _, t := range texts {
+		if strings.Contains(t, s) {
+			return true
+		}
+	}
+	return false
+}
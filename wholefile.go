@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// compileWholeFile returns the regular expression of --match-whole-file: the
+// patterns, taken literally unless regex is set, must match the whole
+// content, with . matching newlines. A final newline is allowed after the
+// match, so a file that ends like most text files do still matches.
+func compileWholeFile(patterns []string, regex, caseInsensitive bool) (*regexp.Regexp, error) {
+	if !regex {
+		quoted := make([]string, len(patterns))
+		for i, p := range patterns {
+			quoted[i] = regexp.QuoteMeta(p)
+		}
+		patterns = quoted
+	}
+	flags := "(?s)"
+	if caseInsensitive {
+		flags = "(?si)"
+	}
+	re, err := regexp.Compile(flags + `\A(?:` + regexAlternation(patterns) + `)\r?\n?\z`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression for --match-whole-file: %v", err)
+	}
+	return re, nil
+}
+
+// processWholeFile reads the whole content of reader and counts the file as
+// one match when opts.WholeFile matches all of it, or with --invert-match
+// when it does not. Like --multiline, files larger than multilineMaxBytes are
+// skipped with a warning.
+func processWholeFile(ctx context.Context, reader *bufio.Reader, fileName string, opts *SearchOptions) error {
+	data, err := io.ReadAll(io.LimitReader(reader, multilineMaxBytes+1))
+	if err != nil {
+		opts.Errors.report(FileError{Path: fileName, Kind: ErrorRead, Err: err}, "Error reading %s: %v", fileName, err)
+		return nil
+	}
+	if len(data) > multilineMaxBytes {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s, larger than %d MiB (--match-whole-file)\n", fileName, multilineMaxBytes>>20)
+		opts.Stats.skipped()
+		return nil
+	}
+	data, _ = opts.ByteLimit.take(data, '\n')
+
+	events, _ := opts.Reporter.(FileEvents)
+	if events != nil {
+		events.Begin(fileName)
+	}
+	n := 0
+	if opts.WholeFile.Match(data) != opts.Invert {
+		n = 1
+	}
+	if events != nil {
+		events.End(fileName, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts.Stats.searched(int64(len(data)), n)
+	opts.Reporter.Count(fileName, n)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Word boundaries accepted by --word-boundary.
+const (
+	WordBoundaryDefault    = "default"
+	WordBoundaryIdentifier = "identifier"
+	WordBoundaryCustom     = "custom"
+)
+
+// NewWordChars returns the word-character predicate of a --word-boundary
+// value, or nil for the default, which is the regexp \b. class is the
+// character class of the custom boundary, written as inside [...], e.g.
+// "A-Za-z0-9_$-".
+func NewWordChars(boundary, class string) (func(rune) bool, error) {
+	switch boundary {
+	case "", WordBoundaryDefault:
+		return nil, nil
+	case WordBoundaryIdentifier:
+		return isIdentifierRune, nil
+	case WordBoundaryCustom:
+		if class == "" {
+			return nil, fmt.Errorf("--word-boundary custom needs --word-chars")
+		}
+		re, err := regexp.Compile("^[" + class + "]$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid --word-chars %q: %v", class, err)
+		}
+		return func(r rune) bool { return re.MatchString(string(r)) }, nil
+	default:
+		return nil, fmt.Errorf("unknown word boundary %q (expected default, identifier or custom)", boundary)
+	}
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// wholeWordPattern is the regular expression of a default --whole-word search
+// for the literal query.
+func wholeWordPattern(query string) string {
+	return `\b` + regexp.QuoteMeta(query) + `\b`
+}
+
+// wordIndex returns the first occurrence of query in s at or after from
+// whose neighbouring characters are not word characters, or -1, -1. Unlike
+// \b, the check does not depend on whether the query itself starts or ends
+// with a word character.
+func wordIndex(s, query []byte, from int, isWord func(rune) bool) (int, int) {
+	if len(query) == 0 {
+		return -1, -1
+	}
+	for from <= len(s)-len(query) {
+		i := bytes.Index(s[from:], query)
+		if i < 0 {
+			break
+		}
+		start, end := from+i, from+i+len(query)
+		before, _ := utf8.DecodeLastRune(s[:start])
+		after, _ := utf8.DecodeRune(s[end:])
+		if (start == 0 || !isWord(before)) && (end == len(s) || !isWord(after)) {
+			return start, end
+		}
+		_, size := utf8.DecodeRune(s[start:])
+		from = start + size
+	}
+	return -1, -1
+}
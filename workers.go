@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	// fileQueueFactor sizes the file queue relative to the starting number
+	// of readers, so the queue depth says whether readers keep up.
+	fileQueueFactor = 4
+
+	// maxReaderFactor caps auto mode at this many readers per CPU.
+	maxReaderFactor = 8
+
+	autoscaleInterval = 50 * time.Millisecond
+
+	// autoscaleSamples is how many consecutive samples must see a backed-up
+	// queue before readers are added.
+	autoscaleSamples = 3
+)
+
+// ParseWorkers parses the --workers value. "auto" (or an empty value)
+// returns 0, which selects the adaptive reader pool.
+func ParseWorkers(value string) (int, error) {
+	if value == "" || value == "auto" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid --workers value %q (expected auto or a positive number)", value)
+	}
+	return n, nil
+}
+
+// initialReaders returns the number of file readers to start with.
+func initialReaders(opts *SearchOptions) int {
+	if opts.Workers > 0 {
+		return opts.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// cpuBound reports whether matching, rather than reading, dominates the cost
+// of a scan. More readers than CPUs only add contention in that case.
+func cpuBound(opts *SearchOptions) bool {
+	return opts.Regex || opts.WholeWord || opts.Fuzzy > 0
+}
+
+// autoscaleReaders grows the reader pool while the file queue stays backed
+// up, which means readers spend their time blocked on I/O rather than using
+// the CPU. start launches n more readers. It returns when done is closed.
+func autoscaleReaders(done <-chan struct{}, queue chan string, readers int, start func(n int)) {
+	limit := runtime.NumCPU() * maxReaderFactor
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+
+	backedUp := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if len(queue)*4 >= cap(queue)*3 {
+				backedUp++
+			} else {
+				backedUp = 0
+			}
+			if backedUp < autoscaleSamples || readers >= limit {
+				continue
+			}
+
+			n := readers / 2
+			if n < 1 {
+				n = 1
+			}
+			if readers+n > limit {
+				n = limit - readers
+			}
+			start(n)
+			readers += n
+			backedUp = 0
+		}
+	}
+}
+
+// cpuSlots bounds the chunks matched at once across all files to its
+// capacity, set with --threads-cpu. Without it, every file read in parallel
+// runs up to one matcher per CPU of its own. A nil cpuSlots has no bound.
+type cpuSlots chan struct{}
+
+// acquire waits for a free slot. It returns false when ctx is done first.
+// A slot taken must be released on every way out, including a panic, so the
+// release belongs in a defer right after acquire.
+func (s cpuSlots) acquire(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken with acquire.
+func (s cpuSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
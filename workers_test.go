@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// busyMatcher records how many lines are matched at once.
+type busyMatcher struct {
+	Matcher
+	running, peak atomic.Int32
+}
+
+func (m *busyMatcher) Match(line []byte) []Span {
+	n := m.running.Add(1)
+	defer m.running.Add(-1)
+	for {
+		peak := m.peak.Load()
+		if n <= peak || m.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(100 * time.Microsecond)
+	return m.Matcher.Match(line)
+}
+
+func TestCPUSlotsBound(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 16; i++ {
+		files[fmt.Sprintf("f%d.txt", i)] = strings.Repeat("hay\nneedle\n", 20)
+	}
+	root := writeTree(t, files)
+	for _, threads := range []int{1, 3} {
+		t.Run(fmt.Sprintf("threads-cpu=%d", threads), func(t *testing.T) {
+			opts, rep := testOptions(t, "needle", func(o *SearchOptions) {
+				o.Workers = 8
+				o.CPUSlots = make(cpuSlots, threads)
+			})
+			m := &busyMatcher{Matcher: opts.Matcher}
+			opts.Matcher = m
+			// The wrapped matcher takes the line by line path, which
+			// is what the slots bound.
+			if got := len(search(t, root, []string{root}, opts, rep)); got != 16*20 {
+				t.Errorf("%d matches, want %d", got, 16*20)
+			}
+			if peak := m.peak.Load(); peak > int32(threads) {
+				t.Errorf("%d lines matched at once, want at most %d", peak, threads)
+			}
+		})
+	}
+}
+
+func TestCPUSlots(t *testing.T) {
+	var unbounded cpuSlots
+	if !unbounded.acquire(context.Background()) {
+		t.Fatal("a nil cpuSlots refused a slot")
+	}
+	unbounded.release()
+
+	slots := make(cpuSlots, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !slots.acquire(ctx) {
+		t.Fatal("no free slot")
+	}
+	cancel()
+	if slots.acquire(ctx) {
+		t.Fatal("a second slot out of one")
+	}
+
+	// Released in a defer, the slot comes back after a panic.
+	func() {
+		defer func() { recover() }()
+		defer slots.release()
+		panic("boom")
+	}()
+	if !slots.acquire(context.Background()) {
+		t.Fatal("the slot was not given back")
+	}
+	slots.release()
+	if len(slots) != 0 {
+		t.Errorf("%d slots still taken", len(slots))
+	}
+}
+
+// BenchmarkThreads searches 40 files of 1 MB case-insensitively with
+// different numbers of readers and matchers.
+func BenchmarkThreads(b *testing.B) {
+	files := make(map[string]string)
+	text := string(benchText(1 << 20))
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("f%02d.log", i)] = text
+	}
+	root := writeTree(b, files)
+	for _, bb := range []struct{ io, cpu int }{{1, 0}, {8, 0}, {8, 1}, {32, 8}} {
+		b.Run(fmt.Sprintf("io=%d/cpu=%d", bb.io, bb.cpu), func(b *testing.B) {
+			opts, _ := testOptions(b, "NEEDLE", func(o *SearchOptions) {
+				o.CaseInsensitive = true
+				o.Workers = bb.io
+				if bb.cpu > 0 {
+					o.CPUSlots = make(cpuSlots, bb.cpu)
+				}
+				o.Reporter = discardReporter{}
+			})
+			b.SetBytes(int64(40 * len(text)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				search(b, root, []string{root}, opts, nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// zipEntrySeparator joins an archive path and an entry name in reported
+// paths, as in archive.zip!dir/file.txt.
+const zipEntrySeparator = "!"
+
+// isZipFile reports whether fileName should be opened as a zip archive.
+func isZipFile(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".zip")
+}
+
+// searchZip runs Process on every entry of the archive at fileName. Entries
+// are filtered by name with the same globs as regular files and binary
+// entries are skipped. A malformed archive is reported and skipped.
+func searchZip(ctx context.Context, fileName string, opts *SearchOptions) {
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		opts.Errors.report(FileError{Path: fileName, Kind: ErrorMalformed, Err: err}, "Warning: skipping malformed archive %s: %v", fileName, err)
+		opts.Stats.skipped()
+		return
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.FileInfo().IsDir() || opts.Filter.SkipFile(entry.Name) {
+			continue
+		}
+		searchZipEntry(ctx, fileName, entry, opts)
+	}
+}
+
+func searchZipEntry(ctx context.Context, fileName string, entry *zip.File, opts *SearchOptions) {
+	path := fileName + zipEntrySeparator + entry.Name
+	rc, err := entry.Open()
+	if err != nil {
+		opts.Errors.report(FileError{Path: path, Kind: ErrorMalformed, Err: err}, "Warning: skipping %s: %v", path, err)
+		return
+	}
+	defer rc.Close()
+
+	reader := bufio.NewReader(rc)
+	if !opts.Text && looksBinary(reader) {
+		opts.Errors.skip(FileError{Path: path, Kind: SkipBinary})
+		opts.Stats.skipped()
+		return
+	}
+	if err := Process(ctx, reader, path, opts); err != nil && ctx.Err() == nil {
+		opts.Errors.report(FileError{Path: path, Kind: ErrorRead, Err: err}, "Warning: error reading %s: %v", path, err)
+	}
+}